@@ -0,0 +1,124 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+)
+
+const (
+    // authBasic authenticates with a user and an API token (Jira Cloud) or password
+    authBasic = "basic"
+    // authBearer authenticates with a personal access token (Jira Server and Data Center)
+    authBearer = "bearer"
+    // authCookie authenticates with a session cookie obtained from a user and password
+    authCookie = "cookie"
+)
+
+// jiraAuth sets the credentials of requests to the Jira API
+type jiraAuth interface {
+    authenticate(req *http.Request) error
+}
+
+// expiringAuth is implemented by auth modes whose credentials can expire
+type expiringAuth interface {
+    jiraAuth
+    // expire drops the credentials so they are obtained again for the next request
+    expire()
+}
+
+func newJiraAuth(instance instanceConfig, httpClient *http.Client) jiraAuth {
+    switch instance.authMode {
+    case authBearer:
+        return bearerAuth{token: instance.jiraAPIToken}
+    case authCookie:
+        return &cookieAuth{jiraURL: instance.jiraURL, user: instance.jiraUser, password: instance.jiraAPIToken, http: httpClient}
+    default:
+        return basicAuth{user: instance.jiraUser, token: instance.jiraAPIToken}
+    }
+}
+
+type basicAuth struct {
+    user  string
+    token string
+}
+
+func (a basicAuth) authenticate(req *http.Request) error {
+    req.SetBasicAuth(a.user, a.token)
+    return nil
+}
+
+type bearerAuth struct {
+    token string
+}
+
+func (a bearerAuth) authenticate(req *http.Request) error {
+    req.Header.Set("Authorization", "Bearer "+a.token)
+    return nil
+}
+
+// cookieAuth logs in with the session resource and sends the session cookie with every request
+type cookieAuth struct {
+    jiraURL  string
+    user     string
+    password string
+    http     *http.Client
+
+    mu      sync.Mutex
+    session *http.Cookie
+}
+
+func (a *cookieAuth) authenticate(req *http.Request) error {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.session == nil {
+        session, err := a.login()
+        if err != nil {
+            return err
+        }
+        a.session = session
+    }
+    req.AddCookie(a.session)
+    return nil
+}
+
+func (a *cookieAuth) expire() {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.session = nil
+}
+
+func (a *cookieAuth) login() (*http.Cookie, error) {
+    body, err := json.Marshal(map[string]string{"username": a.user, "password": a.password})
+    if err != nil {
+        return nil, err
+    }
+    req, err := http.NewRequest("POST", a.jiraURL+"/rest/auth/1/session", bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := a.http.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("failed to log in: %s", resp.Status)
+    }
+
+    var result struct {
+        Session struct {
+            Name  string `json:"name"`
+            Value string `json:"value"`
+        } `json:"session"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, err
+    }
+    return &http.Cookie{Name: result.Session.Name, Value: result.Session.Value}, nil
+}
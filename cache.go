@@ -0,0 +1,101 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// cacheVersion is bumped whenever the cache file layout changes
+const cacheVersion = 3
+
+// issueCache is the on-disk snapshot of an issue store
+type issueCache struct {
+    Version      int          `json:"version"`
+    JiraURL      string       `json:"jiraURL"`
+    JiraUser     string       `json:"jiraUser"`
+    Query        string       `json:"query"`
+    SyncedAt     time.Time    `json:"syncedAt"`
+    FullSyncedAt time.Time    `json:"fullSyncedAt"`
+    Statuses     []JiraStatus `json:"statuses"`
+    Issues       []JiraIssue  `json:"issues"`
+}
+
+// cacheFile returns the path of the cache file of the query of the instance
+func cacheFile(cfg config, instance string, query string) string {
+    return filepath.Join(cfg.cacheDir, instance, query+".json")
+}
+
+// load restores the store from the cache file. A missing file, a file of another
+// version or one written for another Jira or query leaves the store empty.
+func (s *issueStore) load(path string, instance instanceConfig) error {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    var cache issueCache
+    if err := json.Unmarshal(data, &cache); err != nil {
+        return fmt.Errorf("failed to decode %s: %w", path, err)
+    }
+    if cache.Version != cacheVersion || cache.JiraURL != instance.jiraURL || cache.JiraUser != instance.jiraUser || cache.Query != s.query.jql {
+        fmt.Printf("Ignoring the cache %s written for another version, Jira or query\n", path)
+        return nil
+    }
+    s.issues = make(map[string]JiraIssue, len(cache.Issues))
+    for _, issue := range cache.Issues {
+        s.issues[issue.Key] = issue
+    }
+    s.statuses = cache.Statuses
+    s.syncedAt = cache.SyncedAt
+    s.fullSyncedAt = cache.FullSyncedAt
+    return nil
+}
+
+// save writes the store to the cache file. The file is replaced atomically, so a crash
+// in the middle of a write leaves the previous cache in place.
+func (s *issueStore) save(path string, instance instanceConfig) error {
+    cache := issueCache{
+        Version:      cacheVersion,
+        JiraURL:      instance.jiraURL,
+        JiraUser:     instance.jiraUser,
+        Query:        s.query.jql,
+        SyncedAt:     s.syncedAt,
+        FullSyncedAt: s.fullSyncedAt,
+        Statuses:     s.statuses,
+        Issues:       make([]JiraIssue, 0, len(s.issues)),
+    }
+    for _, issue := range s.issues {
+        cache.Issues = append(cache.Issues, issue)
+    }
+    data, err := json.Marshal(cache)
+    if err != nil {
+        return err
+    }
+
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
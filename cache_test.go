@@ -0,0 +1,50 @@
+package main
+
+import (
+    "path/filepath"
+    "slices"
+    "testing"
+    "time"
+)
+
+func TestIssueStoreCache(t *testing.T) {
+    syncedAt := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+    query := queryConfig{name: "default", jql: "project = A"}
+    instance := instanceConfig{name: "a", jiraURL: "http://jira", jiraUser: "alice"}
+    tests := []struct {
+        name     string
+        instance instanceConfig
+        jql      string
+        wantKeys []string
+    }{
+        {"same Jira and query", instance, "project = A", []string{"A-1"}},
+        {"another query", instance, "project = B", []string{}},
+        {"another URL", instanceConfig{name: "a", jiraURL: "http://other", jiraUser: "alice"}, "project = A", []string{}},
+        {"another user", instanceConfig{name: "a", jiraURL: "http://jira", jiraUser: "bob"}, "project = A", []string{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "a", "default.json")
+            saved := newIssueStore(query)
+            saved.issues["A-1"] = testIssue("A-1", syncedAt)
+            saved.syncedAt, saved.fullSyncedAt = syncedAt, syncedAt
+            if err := saved.save(path, instance); err != nil {
+                t.Fatal(err)
+            }
+            loaded := newIssueStore(queryConfig{name: "default", jql: tt.jql})
+            if err := loaded.load(path, tt.instance); err != nil {
+                t.Fatal(err)
+            }
+            if keys := storedKeys(loaded); !slices.Equal(keys, tt.wantKeys) {
+                t.Errorf("loaded issues = %v, want %v", keys, tt.wantKeys)
+            }
+            wantSyncedAt := time.Time{}
+            if len(tt.wantKeys) > 0 {
+                wantSyncedAt = syncedAt
+            }
+            if !loaded.syncedAt.Equal(wantSyncedAt) || !loaded.fullSyncedAt.Equal(wantSyncedAt) {
+                t.Errorf("syncedAt, fullSyncedAt = %s, %s, want %s", loaded.syncedAt, loaded.fullSyncedAt, wantSyncedAt)
+            }
+        })
+    }
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "time"
+)
+
+// cfdRow is the number of issues of a query in a status at the end of a day, one point
+// of a cumulative flow diagram
+type cfdRow struct {
+    Instance       string `json:"instance"`
+    Query          string `json:"query"`
+    Date           string `json:"date"`
+    Status         string `json:"status"`
+    StatusCategory string `json:"statusCategory"`
+    Count          int    `json:"count"`
+}
+
+// categoryOrder orders the statuses of a cumulative flow diagram from done to new,
+// so the done band is at the bottom of the stack
+var categoryOrder = map[string]int{"done": 0, "indeterminate": 1, "new": 2}
+
+// cumulativeFlow reconstructs from the changelogs how many of the issues were in each
+// status at the end of each of the last days, today included. Every status of the
+// issues has a row for every day, so the bands of the diagram are continuous.
+func cumulativeFlow(ctx issueContext, issues map[string]JiraIssue, days int) []cfdRow {
+    today := ctx.now.UTC().Truncate(24 * time.Hour)
+    ends := make([]time.Time, days)
+    counts := make([]map[string]int, days)
+    for i := range ends {
+        ends[i] = today.AddDate(0, 0, i-days+1).Add(24 * time.Hour)
+        if ends[i].After(ctx.now) {
+            ends[i] = ctx.now
+        }
+        counts[i] = make(map[string]int)
+    }
+
+    categories := make(map[string]statusCategory)
+    for _, issue := range issues {
+        created := mustTimeParse(issue.Fields.Created)
+        transitions := statusTransitions(issue)
+        status := issue.Fields.Status.Name
+        categories[status] = statusCategory{key: issue.Fields.Status.StatusCategory.Key, name: issue.Fields.Status.StatusCategory.Name}
+        if len(transitions) > 0 {
+            status = transitions[0].from.name
+        }
+        for _, transition := range transitions {
+            for _, ref := range []statusRef{transition.from, transition.to} {
+                if _, ok := categories[ref.name]; !ok {
+                    categories[ref.name] = ctx.categories.lookup(ref.id, ref.name)
+                }
+            }
+        }
+        next := 0
+        for i, end := range ends {
+            if end.Before(created) {
+                continue
+            }
+            for next < len(transitions) && !transitions[next].at.After(end) {
+                status = transitions[next].to.name
+                next++
+            }
+            counts[i][status]++
+        }
+    }
+
+    statuses := make([]string, 0, len(categories))
+    for status := range categories {
+        statuses = append(statuses, status)
+    }
+    sort.Slice(statuses, func(i, j int) bool {
+        oi, oj := categoryOrder[categories[statuses[i]].key], categoryOrder[categories[statuses[j]].key]
+        if oi != oj {
+            return oi < oj
+        }
+        return statuses[i] < statuses[j]
+    })
+    rows := make([]cfdRow, 0, days*len(statuses))
+    for i, end := range ends {
+        date := end.Add(-time.Nanosecond).Format(time.DateOnly)
+        for _, status := range statuses {
+            rows = append(rows, cfdRow{
+                Instance:       ctx.instance,
+                Query:          ctx.query,
+                Date:           date,
+                Status:         status,
+                StatusCategory: categories[status].name,
+                Count:          counts[i][status],
+            })
+        }
+    }
+    return rows
+}
+
+// cfdHandler serves the cumulative flow of every query as a JSON array of rows, or of
+// the queries matching the instance and query parameters
+func cfdHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        rows := issueMetrics.cumulativeFlow(r.URL.Query().Get("instance"), r.URL.Query().Get("query"))
+        w.Header().Set("Content-Type", "application/json")
+        if err := json.NewEncoder(w).Encode(rows); err != nil {
+            fmt.Printf("Error writing cumulative flow: %s\n", err)
+        }
+    })
+}
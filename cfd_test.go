@@ -0,0 +1,85 @@
+package main
+
+import (
+    "slices"
+    "testing"
+    "time"
+)
+
+// testStatuses are the workflow statuses of the test issues by name
+var testStatuses = map[string]struct{ id, category, categoryName string }{
+    "To Do":       {"1", "new", "To Do"},
+    "In Progress": {"3", "indeterminate", "In Progress"},
+    "Done":        {"5", "done", "Done"},
+}
+
+func testStatusCategories() statusCategories {
+    statuses := make([]JiraStatus, 0, len(testStatuses))
+    for name, s := range testStatuses {
+        status := JiraStatus{ID: s.id, Name: name}
+        status.StatusCategory.Key = s.category
+        status.StatusCategory.Name = s.categoryName
+        statuses = append(statuses, status)
+    }
+    return newStatusCategories(statuses)
+}
+
+// statusChange is a move of a test issue into a status
+type statusChange struct {
+    at time.Time
+    to string
+}
+
+// testWorkflowIssue returns an issue created in To Do that went through the status changes
+func testWorkflowIssue(key string, created time.Time, changes ...statusChange) JiraIssue {
+    issue := testIssue(key, created)
+    issue.Fields.Created = created.Format(jiraTimeFormat)
+    status := "To Do"
+    for _, change := range changes {
+        at, to := change.at, change.to
+        issue.Changelog.Histories = append(issue.Changelog.Histories, JiraHistory{
+            ID:      key + "/" + at.Format(time.RFC3339),
+            Created: at.Format(jiraTimeFormat),
+            Items: []JiraHistoryItem{{
+                Field:      "status",
+                From:       testStatuses[status].id,
+                FromString: status,
+                To:         testStatuses[to].id,
+                ToString:   to,
+            }},
+        })
+        issue.Fields.Updated = at.Format(jiraTimeFormat)
+        status = to
+    }
+    issue.Fields.Status.Name = status
+    issue.Fields.Status.StatusCategory.Key = testStatuses[status].category
+    issue.Fields.Status.StatusCategory.Name = testStatuses[status].categoryName
+    return issue
+}
+
+func TestCumulativeFlow(t *testing.T) {
+    day := func(d int, hour int) time.Time { return time.Date(2024, 5, d, hour, 0, 0, 0, time.UTC) }
+    issues := map[string]JiraIssue{
+        "A-1": testWorkflowIssue("A-1", day(7, 10), statusChange{day(8, 9), "In Progress"}, statusChange{day(10, 8), "Done"}),
+        "A-2": testWorkflowIssue("A-2", day(9, 10)),
+        "A-3": testWorkflowIssue("A-3", day(8, 10), statusChange{day(9, 23), "In Progress"}),
+    }
+    ctx := issueContext{instance: "default", query: "default", categories: testStatusCategories(), now: day(10, 12)}
+    row := func(date string, status string, count int) cfdRow {
+        return cfdRow{Instance: "default", Query: "default", Date: date, Status: status, StatusCategory: testStatuses[status].categoryName, Count: count}
+    }
+    want := []cfdRow{
+        row("2024-05-08", "Done", 0),
+        row("2024-05-08", "In Progress", 1),
+        row("2024-05-08", "To Do", 1),
+        row("2024-05-09", "Done", 0),
+        row("2024-05-09", "In Progress", 2),
+        row("2024-05-09", "To Do", 1),
+        row("2024-05-10", "Done", 1),
+        row("2024-05-10", "In Progress", 1),
+        row("2024-05-10", "To Do", 1),
+    }
+    if got := cumulativeFlow(ctx, issues, 3); !slices.Equal(got, want) {
+        t.Errorf("cumulativeFlow() =\n%v\nwant\n%v", got, want)
+    }
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// issueCollector serves the issue metrics from the last published snapshot of
+// every query. A snapshot is built off to the side and swapped in at once, so a
+// scrape always sees one complete refresh of each query.
+type issueCollector struct {
+    // dataAge is the desc of the age of the snapshot of each query
+    dataAge *prometheus.Desc
+    descs   []*prometheus.Desc
+
+    mu        sync.RWMutex
+    snapshots map[string]snapshot
+}
+
+// snapshot is the metrics of one refresh of a query
+type snapshot struct {
+    instance   string
+    query      string
+    metrics    []prometheus.Metric
+    flow       []cfdRow
+    throughput []throughputRow
+    syncedAt   time.Time
+}
+
+func newIssueCollector(dataAge *prometheus.Desc, descs ...*prometheus.Desc) *issueCollector {
+    return &issueCollector{
+        dataAge:   dataAge,
+        descs:     descs,
+        snapshots: make(map[string]snapshot),
+    }
+}
+
+// Describe implements prometheus.Collector
+func (c *issueCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- c.dataAge
+    for _, desc := range c.descs {
+        ch <- desc
+    }
+}
+
+// Collect implements prometheus.Collector
+func (c *issueCollector) Collect(ch chan<- prometheus.Metric) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    for _, s := range c.snapshots {
+        ch <- prometheus.MustNewConstMetric(c.dataAge, prometheus.GaugeValue, time.Since(s.syncedAt).Seconds(), s.instance, s.query)
+        for _, metric := range s.metrics {
+            ch <- metric
+        }
+    }
+}
+
+// publish replaces the served metrics, cumulative flow and throughput of the query of the
+// instance with the ones from the builder
+func (c *issueCollector) publish(instance string, query string, b *snapshotBuilder, flow []cfdRow, throughput []throughputRow, syncedAt time.Time) {
+    s := snapshot{instance: instance, query: query, metrics: b.build(), flow: flow, throughput: throughput, syncedAt: syncedAt}
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.snapshots[instance+"/"+query] = s
+}
+
+// syncedAt returns the sync time of the served snapshot of the query of the instance,
+// or false when none has been published yet
+func (c *issueCollector) syncedAt(instance string, query string) (time.Time, bool) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    s, ok := c.snapshots[instance+"/"+query]
+    return s.syncedAt, ok
+}
+
+// cumulativeFlow returns the cumulative flow of the queries, or only of the instance
+// and the query when they are not empty, ordered by instance and query
+func (c *issueCollector) cumulativeFlow(instance string, query string) []cfdRow {
+    rows := make([]cfdRow, 0)
+    for _, s := range c.matching(instance, query) {
+        rows = append(rows, s.flow...)
+    }
+    return rows
+}
+
+// throughput returns the daily throughput of the queries, or only of the instance and
+// the query when they are not empty, ordered by instance and query
+func (c *issueCollector) throughput(instance string, query string) []throughputRow {
+    rows := make([]throughputRow, 0)
+    for _, s := range c.matching(instance, query) {
+        rows = append(rows, s.throughput...)
+    }
+    return rows
+}
+
+// matching returns the snapshots of the queries, or only of the instance and the query
+// when they are not empty, ordered by instance and query
+func (c *issueCollector) matching(instance string, query string) []snapshot {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    keys := make([]string, 0, len(c.snapshots))
+    for key, s := range c.snapshots {
+        if (instance == "" || s.instance == instance) && (query == "" || s.query == query) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    snapshots := make([]snapshot, 0, len(keys))
+    for _, key := range keys {
+        snapshots = append(snapshots, c.snapshots[key])
+    }
+    return snapshots
+}
+
+// remove stops serving the metrics of the query of the instance
+func (c *issueCollector) remove(instance string, query string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    delete(c.snapshots, instance+"/"+query)
+}
+
+// snapshotBuilder accumulates the metric values of one refresh
+type snapshotBuilder struct {
+    values     map[string]*constValue
+    histograms map[string]*constHistogram
+}
+
+type constValue struct {
+    desc        *prometheus.Desc
+    valueType   prometheus.ValueType
+    labelValues []string
+    value       float64
+}
+
+type constHistogram struct {
+    desc        *prometheus.Desc
+    labelValues []string
+    buckets     []float64
+    counts      []uint64
+    count       uint64
+    sum         float64
+}
+
+func newSnapshotBuilder() *snapshotBuilder {
+    return &snapshotBuilder{
+        values:     make(map[string]*constValue),
+        histograms: make(map[string]*constHistogram),
+    }
+}
+
+// addGauge adds v to the gauge identified by desc and labelValues
+func (b *snapshotBuilder) addGauge(desc *prometheus.Desc, v float64, labelValues ...string) {
+    b.add(desc, prometheus.GaugeValue, v, labelValues)
+}
+
+// addCounter adds v to the counter identified by desc and labelValues
+func (b *snapshotBuilder) addCounter(desc *prometheus.Desc, v float64, labelValues ...string) {
+    b.add(desc, prometheus.CounterValue, v, labelValues)
+}
+
+// maxGauge raises the gauge identified by desc and labelValues to v when v is greater
+func (b *snapshotBuilder) maxGauge(desc *prometheus.Desc, v float64, labelValues ...string) {
+    key := seriesKey(desc, labelValues)
+    value, ok := b.values[key]
+    if !ok {
+        b.values[key] = &constValue{desc: desc, valueType: prometheus.GaugeValue, labelValues: labelValues, value: v}
+        return
+    }
+    value.value = max(value.value, v)
+}
+
+func (b *snapshotBuilder) add(desc *prometheus.Desc, valueType prometheus.ValueType, v float64, labelValues []string) {
+    key := seriesKey(desc, labelValues)
+    value, ok := b.values[key]
+    if !ok {
+        value = &constValue{desc: desc, valueType: valueType, labelValues: labelValues}
+        b.values[key] = value
+    }
+    value.value += v
+}
+
+// observe adds v to the histogram identified by desc and labelValues
+func (b *snapshotBuilder) observe(desc *prometheus.Desc, buckets []float64, v float64, labelValues ...string) {
+    key := seriesKey(desc, labelValues)
+    histogram, ok := b.histograms[key]
+    if !ok {
+        histogram = &constHistogram{
+            desc:        desc,
+            labelValues: labelValues,
+            buckets:     buckets,
+            counts:      make([]uint64, len(buckets)),
+        }
+        b.histograms[key] = histogram
+    }
+    for i, upperBound := range buckets {
+        if v <= upperBound {
+            histogram.counts[i]++
+        }
+    }
+    histogram.count++
+    histogram.sum += v
+}
+
+// build turns the accumulated values into constant metrics
+func (b *snapshotBuilder) build() []prometheus.Metric {
+    metrics := make([]prometheus.Metric, 0, len(b.values)+len(b.histograms))
+    for _, value := range b.values {
+        metrics = append(metrics, prometheus.MustNewConstMetric(value.desc, value.valueType, value.value, value.labelValues...))
+    }
+    for _, histogram := range b.histograms {
+        buckets := make(map[float64]uint64, len(histogram.buckets))
+        for i, upperBound := range histogram.buckets {
+            buckets[upperBound] = histogram.counts[i]
+        }
+        metrics = append(metrics, prometheus.MustNewConstHistogram(histogram.desc, histogram.count, histogram.sum, buckets, histogram.labelValues...))
+    }
+    return metrics
+}
+
+func seriesKey(desc *prometheus.Desc, labelValues []string) string {
+    return desc.String() + "\xff" + strings.Join(labelValues, "\xff")
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// defaultInstanceName is the name of the instance configured with the JIRA_* envs
+const defaultInstanceName = "default"
+
+// envReferencePattern matches the ${NAME} references to envs in the config file
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+type config struct {
+    listen               string
+    dataRefreshPeriod    time.Duration
+    dataRetryPeriod      time.Duration
+    pageSize             int
+    changelogConcurrency int
+    requestTimeout       time.Duration
+    maxRetries           int
+    retryBackoff         time.Duration
+    requestsPerSecond    float64
+    analyzePeriodDays    int
+    fullSyncPeriod       time.Duration
+    syncOverlap          time.Duration
+    cacheDir             string
+    readinessMaxAge      time.Duration
+    staleThresholds      []time.Duration
+    instances            []instanceConfig
+}
+
+// instanceConfig is a Jira instance with its credentials and queries. Its issues
+// are exported with the instance label.
+type instanceConfig struct {
+    name         string
+    jiraURL      string
+    jiraUser     string
+    jiraAPIToken string
+    flavor       string
+    authMode     string
+    searchAPI    string
+    projects     string
+    stages       stageMapping
+    activity     statusActivity
+    queries      []queryConfig
+}
+
+// configSpec is the schema of the config file. Every setting can be overridden with its env.
+type configSpec struct {
+    Listen               string         `json:"listen"`
+    DataRefreshPeriod    string         `json:"dataRefreshPeriod"`
+    DataRetryPeriod      string         `json:"dataRetryPeriod"`
+    AnalyzePeriodDays    int            `json:"analyzePeriodDays"`
+    FullSyncPeriod       string         `json:"fullSyncPeriod"`
+    SyncOverlap          string         `json:"syncOverlap"`
+    CacheDir             string         `json:"cacheDir"`
+    PageSize             int            `json:"pageSize"`
+    ChangelogConcurrency int            `json:"changelogConcurrency"`
+    RequestTimeout       string         `json:"requestTimeout"`
+    MaxRetries           int            `json:"maxRetries"`
+    RetryBackoff         string         `json:"retryBackoff"`
+    RequestsPerSecond    float64        `json:"requestsPerSecond"`
+    ReadinessMaxAge      string         `json:"readinessMaxAge"`
+    StaleThresholds      []string       `json:"staleThresholds"`
+    Instances            []instanceSpec `json:"instances"`
+}
+
+// instanceSpec is an entry of the instances of the config file or of JIRA_INSTANCES
+type instanceSpec struct {
+    Name      string               `json:"name"`
+    URL       string               `json:"url"`
+    User      string               `json:"user"`
+    APIToken  string               `json:"apiToken"`
+    Auth      string               `json:"auth"`
+    Flavor    string               `json:"flavor"`
+    SearchAPI string               `json:"searchAPI"`
+    Projects  string               `json:"projects"`
+    JQL       string               `json:"jql"`
+    Stages    map[string]string    `json:"stages"`
+    Active    []string             `json:"activeStatuses"`
+    Waiting   []string             `json:"waitingStatuses"`
+    Queries   map[string]querySpec `json:"queries"`
+}
+
+// configError is an invalid setting at the path of the config file
+type configError struct {
+    path string
+    err  error
+}
+
+func (e *configError) Error() string {
+    return e.path + ": " + e.err.Error()
+}
+
+func (e *configError) Unwrap() error {
+    return e.err
+}
+
+// withPath prefixes the path of the config error with the parent path, or makes a
+// config error at the parent path from any other error
+func withPath(parent string, err error) error {
+    var ce *configError
+    if errors.As(err, &ce) {
+        return &configError{path: parent + "." + ce.path, err: ce.err}
+    }
+    return &configError{path: parent, err: err}
+}
+
+func defaultConfigSpec() configSpec {
+    return configSpec{
+        DataRefreshPeriod:    "5m",
+        DataRetryPeriod:      "1m",
+        AnalyzePeriodDays:    90,
+        FullSyncPeriod:       "1h",
+        SyncOverlap:          "5m",
+        PageSize:             100,
+        ChangelogConcurrency: 4,
+        RequestTimeout:       "30s",
+        MaxRetries:           5,
+        RetryBackoff:         "1s",
+        ReadinessMaxAge:      "1h",
+        StaleThresholds:      []string{"72h", "168h", "336h"},
+    }
+}
+
+// loadConfig reads the config file, if any, overrides its settings with the envs
+// that are set and validates the result. Errors in the file name its line.
+func loadConfig(path string) (config, error) {
+    spec := defaultConfigSpec()
+    var lines map[string]int
+    if path != "" {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return config{}, err
+        }
+        data, err = expandEnvReferences(data)
+        if err != nil {
+            return config{}, fmt.Errorf("%s:%w", path, err)
+        }
+        dec := json.NewDecoder(bytes.NewReader(data))
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&spec); err != nil {
+            return config{}, fmt.Errorf("%s:%d: %w", path, decodeErrorLine(data, dec, err), err)
+        }
+        if dec.More() {
+            return config{}, fmt.Errorf("%s:%d: unexpected data after the config", path, lineAt(data, dec.InputOffset()))
+        }
+        lines = jsonLines(data)
+    }
+    envPaths, err := applyEnvOverrides(&spec)
+    if err != nil {
+        return config{}, err
+    }
+    cfg, err := newConfig(spec)
+    var ce *configError
+    if err != nil && errors.As(err, &ce) {
+        // Name the env of a setting the envs replaced, it isn't the value in the file
+        for p := ce.path; p != ""; p = parentPath(p) {
+            if name, ok := envPaths[p]; ok {
+                if name == "" {
+                    return cfg, err
+                }
+                return cfg, fmt.Errorf("%s: %w", name, err)
+            }
+        }
+    }
+    if err != nil && errors.As(err, &ce) && lines != nil {
+        // Report the line of the closest setting present in the file
+        for p := ce.path; p != ""; p = parentPath(p) {
+            if line, ok := lines[p]; ok {
+                return cfg, fmt.Errorf("%s:%d: %w", path, line, err)
+            }
+        }
+    }
+    return cfg, err
+}
+
+// applyEnvOverrides overrides the settings of the spec with the envs that are set and
+// returns the names of the envs by the paths of the settings they replaced. The settings
+// of an instance created from the envs map to an empty name.
+func applyEnvOverrides(spec *configSpec) (map[string]string, error) {
+    envPaths := make(map[string]string)
+    strs := []struct {
+        name  string
+        path  string
+        value *string
+    }{
+        {"LISTEN", "listen", &spec.Listen},
+        {"CACHE_DIR", "cacheDir", &spec.CacheDir},
+        {"DATA_REFRESH_PERIOD", "dataRefreshPeriod", &spec.DataRefreshPeriod},
+        {"DATA_RETRY_PERIOD", "dataRetryPeriod", &spec.DataRetryPeriod},
+        {"FULL_SYNC_PERIOD", "fullSyncPeriod", &spec.FullSyncPeriod},
+        {"SYNC_OVERLAP", "syncOverlap", &spec.SyncOverlap},
+        {"JIRA_REQUEST_TIMEOUT", "requestTimeout", &spec.RequestTimeout},
+        {"JIRA_RETRY_BACKOFF", "retryBackoff", &spec.RetryBackoff},
+        {"READINESS_MAX_AGE", "readinessMaxAge", &spec.ReadinessMaxAge},
+    }
+    for _, env := range strs {
+        if value := os.Getenv(env.name); value != "" {
+            *env.value = value
+            envPaths[env.path] = env.name
+        }
+    }
+    if value := os.Getenv("STALE_THRESHOLDS"); value != "" {
+        spec.StaleThresholds = strings.Split(value, ",")
+        envPaths["staleThresholds"] = "STALE_THRESHOLDS"
+    }
+    ints := []struct {
+        name  string
+        path  string
+        value *int
+    }{
+        {"ANALYZE_PERIOD_DAYS", "analyzePeriodDays", &spec.AnalyzePeriodDays},
+        {"JIRA_PAGE_SIZE", "pageSize", &spec.PageSize},
+        {"JIRA_CHANGELOG_CONCURRENCY", "changelogConcurrency", &spec.ChangelogConcurrency},
+        {"JIRA_MAX_RETRIES", "maxRetries", &spec.MaxRetries},
+    }
+    for _, env := range ints {
+        if value := os.Getenv(env.name); value != "" {
+            parsed, err := strconv.Atoi(value)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %w", env.name, err)
+            }
+            *env.value = parsed
+            envPaths[env.path] = env.name
+        }
+    }
+    if value := os.Getenv("JIRA_REQUESTS_PER_SECOND"); value != "" {
+        parsed, err := strconv.ParseFloat(value, 64)
+        if err != nil {
+            return nil, fmt.Errorf("JIRA_REQUESTS_PER_SECOND: %w", err)
+        }
+        spec.RequestsPerSecond = parsed
+        envPaths["requestsPerSecond"] = "JIRA_REQUESTS_PER_SECOND"
+    }
+
+    if value := os.Getenv("JIRA_INSTANCES"); value != "" {
+        spec.Instances = nil
+        dec := json.NewDecoder(bytes.NewReader([]byte(value)))
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&spec.Instances); err != nil {
+            return nil, fmt.Errorf("failed to parse JIRA_INSTANCES: %w", err)
+        }
+        envPaths["instances"] = "JIRA_INSTANCES"
+    }
+
+    // The envs of a single instance override the only configured instance
+    instanceEnvs := []string{"JIRA_URL", "JIRA_USER", "JIRA_API_TOKEN", "JIRA_AUTH", "JIRA_FLAVOR", "JIRA_SEARCH_API", "PROJECTS", "JQL", "QUERIES", "STAGES", "ACTIVE_STATUSES", "WAITING_STATUSES"}
+    set := slices.IndexFunc(instanceEnvs, func(name string) bool { return os.Getenv(name) != "" })
+    if set < 0 {
+        return envPaths, nil
+    }
+    switch len(spec.Instances) {
+    case 0:
+        spec.Instances = []instanceSpec{{Name: defaultInstanceName}}
+        if _, ok := envPaths["instances"]; !ok {
+            envPaths["instances[0]"] = ""
+        }
+    case 1:
+    default:
+        return nil, fmt.Errorf("%s can't override one of %d instances", instanceEnvs[set], len(spec.Instances))
+    }
+    instance := &spec.Instances[0]
+    instanceStrs := []struct {
+        name  string
+        path  string
+        value *string
+    }{
+        {"JIRA_URL", "url", &instance.URL},
+        {"JIRA_USER", "user", &instance.User},
+        {"JIRA_API_TOKEN", "apiToken", &instance.APIToken},
+        {"JIRA_AUTH", "auth", &instance.Auth},
+        {"JIRA_FLAVOR", "flavor", &instance.Flavor},
+        {"JIRA_SEARCH_API", "searchAPI", &instance.SearchAPI},
+        {"PROJECTS", "projects", &instance.Projects},
+        {"JQL", "jql", &instance.JQL},
+    }
+    for _, env := range instanceStrs {
+        if value := os.Getenv(env.name); value != "" {
+            *env.value = value
+            envPaths["instances[0]."+env.path] = env.name
+        }
+    }
+    if value := os.Getenv("QUERIES"); value != "" {
+        instance.Queries = nil
+        if err := json.Unmarshal([]byte(value), &instance.Queries); err != nil {
+            return nil, fmt.Errorf("failed to parse QUERIES: %w", err)
+        }
+        if len(instance.Queries) == 0 {
+            return nil, fmt.Errorf("QUERIES has no queries")
+        }
+        envPaths["instances[0].queries"] = "QUERIES"
+    }
+    if _, ok := envPaths["instances[0].jql"]; ok && len(instance.Queries) == 0 {
+        // The JQL is the template of the default query
+        envPaths["instances[0].queries."+defaultQueryName+".jql"] = "JQL"
+    }
+    if value := os.Getenv("STAGES"); value != "" {
+        instance.Stages = nil
+        if err := json.Unmarshal([]byte(value), &instance.Stages); err != nil {
+            return nil, fmt.Errorf("failed to parse STAGES: %w", err)
+        }
+        envPaths["instances[0].stages"] = "STAGES"
+    }
+    if value := os.Getenv("ACTIVE_STATUSES"); value != "" {
+        instance.Active = strings.Split(value, ",")
+        envPaths["instances[0].activeStatuses"] = "ACTIVE_STATUSES"
+    }
+    if value := os.Getenv("WAITING_STATUSES"); value != "" {
+        instance.Waiting = strings.Split(value, ",")
+        envPaths["instances[0].waitingStatuses"] = "WAITING_STATUSES"
+    }
+    return envPaths, nil
+}
+
+// newConfig validates the spec and converts it to the config
+func newConfig(spec configSpec) (config, error) {
+    cfg := config{
+        listen:               spec.Listen,
+        pageSize:             spec.PageSize,
+        changelogConcurrency: spec.ChangelogConcurrency,
+        maxRetries:           spec.MaxRetries,
+        requestsPerSecond:    spec.RequestsPerSecond,
+        analyzePeriodDays:    spec.AnalyzePeriodDays,
+        cacheDir:             spec.CacheDir,
+    }
+    if cfg.listen == "" {
+        return cfg, &configError{path: "listen", err: errors.New("is empty")}
+    }
+    durations := []struct {
+        path  string
+        spec  string
+        value *time.Duration
+    }{
+        {"dataRefreshPeriod", spec.DataRefreshPeriod, &cfg.dataRefreshPeriod},
+        {"dataRetryPeriod", spec.DataRetryPeriod, &cfg.dataRetryPeriod},
+        {"fullSyncPeriod", spec.FullSyncPeriod, &cfg.fullSyncPeriod},
+        {"syncOverlap", spec.SyncOverlap, &cfg.syncOverlap},
+        {"requestTimeout", spec.RequestTimeout, &cfg.requestTimeout},
+        {"retryBackoff", spec.RetryBackoff, &cfg.retryBackoff},
+        {"readinessMaxAge", spec.ReadinessMaxAge, &cfg.readinessMaxAge},
+    }
+    for _, setting := range durations {
+        value, err := time.ParseDuration(setting.spec)
+        if err != nil {
+            return cfg, &configError{path: setting.path, err: err}
+        }
+        *setting.value = value
+    }
+    for i, threshold := range spec.StaleThresholds {
+        path := fmt.Sprintf("staleThresholds[%d]", i)
+        value, err := time.ParseDuration(strings.TrimSpace(threshold))
+        if err != nil {
+            return cfg, &configError{path: path, err: err}
+        }
+        if value <= 0 {
+            return cfg, &configError{path: path, err: errors.New("must be positive")}
+        }
+        cfg.staleThresholds = append(cfg.staleThresholds, value)
+    }
+    slices.Sort(cfg.staleThresholds)
+    cfg.staleThresholds = slices.Compact(cfg.staleThresholds)
+    settings := []struct {
+        path     string
+        value    float64
+        positive bool
+    }{
+        {"dataRefreshPeriod", float64(cfg.dataRefreshPeriod), true},
+        {"dataRetryPeriod", float64(cfg.dataRetryPeriod), true},
+        {"analyzePeriodDays", float64(cfg.analyzePeriodDays), true},
+        {"fullSyncPeriod", float64(cfg.fullSyncPeriod), true},
+        {"syncOverlap", float64(cfg.syncOverlap), false},
+        {"pageSize", float64(cfg.pageSize), true},
+        {"changelogConcurrency", float64(cfg.changelogConcurrency), true},
+        {"requestTimeout", float64(cfg.requestTimeout), true},
+        {"readinessMaxAge", float64(cfg.readinessMaxAge), true},
+        {"maxRetries", float64(cfg.maxRetries), false},
+        {"retryBackoff", float64(cfg.retryBackoff), false},
+        {"requestsPerSecond", cfg.requestsPerSecond, false},
+    }
+    for _, setting := range settings {
+        if setting.positive && setting.value <= 0 {
+            return cfg, &configError{path: setting.path, err: errors.New("must be positive")}
+        }
+        if setting.value < 0 {
+            return cfg, &configError{path: setting.path, err: errors.New("must not be negative")}
+        }
+    }
+    if len(spec.Instances) == 0 {
+        return cfg, &configError{path: "instances", err: errors.New("no Jira instance is configured")}
+    }
+    for i, instanceSpec := range spec.Instances {
+        path := fmt.Sprintf("instances[%d]", i)
+        instance, err := newInstanceConfig(cfg, instanceSpec)
+        if err != nil {
+            return cfg, withPath(path, err)
+        }
+        if slices.ContainsFunc(cfg.instances, func(other instanceConfig) bool { return other.name == instance.name }) {
+            return cfg, &configError{path: path + ".name", err: fmt.Errorf("instance %s is configured twice", instance.name)}
+        }
+        cfg.instances = append(cfg.instances, instance)
+    }
+    return cfg, nil
+}
+
+// newInstanceConfig validates the instance spec and fills in the defaults
+func newInstanceConfig(cfg config, spec instanceSpec) (instanceConfig, error) {
+    instance := instanceConfig{
+        name:         spec.Name,
+        jiraURL:      spec.URL,
+        jiraUser:     spec.User,
+        jiraAPIToken: spec.APIToken,
+        flavor:       valueOrDefault(spec.Flavor, flavorAuto),
+        authMode:     valueOrDefault(spec.Auth, authBasic),
+        searchAPI:    valueOrDefault(spec.SearchAPI, searchAPIAuto),
+        projects:     spec.Projects,
+    }
+    if !namePattern.MatchString(instance.name) {
+        return instance, &configError{path: "name", err: errors.New("may only contain letters, digits, '_' and '-'")}
+    }
+    if instance.jiraURL == "" {
+        return instance, &configError{path: "url", err: errors.New("is empty")}
+    }
+    if instance.jiraAPIToken == "" {
+        return instance, &configError{path: "apiToken", err: errors.New("is empty")}
+    }
+    if !slices.Contains([]string{flavorAuto, flavorCloud, flavorServer}, instance.flavor) {
+        return instance, &configError{path: "flavor", err: fmt.Errorf("unknown flavor %q", instance.flavor)}
+    }
+    if !slices.Contains([]string{authBasic, authBearer, authCookie}, instance.authMode) {
+        return instance, &configError{path: "auth", err: fmt.Errorf("unknown auth %q", instance.authMode)}
+    }
+    if instance.authMode != authBearer && instance.jiraUser == "" {
+        return instance, &configError{path: "user", err: errors.New("is empty")}
+    }
+    if !slices.Contains([]string{searchAPIAuto, searchAPIJQL, searchAPILegacy}, instance.searchAPI) {
+        return instance, &configError{path: "searchAPI", err: fmt.Errorf("unknown searchAPI %q", instance.searchAPI)}
+    }
+    stages, err := newStageMapping(spec.Stages)
+    if err != nil {
+        return instance, withPath("stages", err)
+    }
+    instance.stages = stages
+    activity, err := newStatusActivity(spec.Active, spec.Waiting)
+    if err != nil {
+        return instance, &configError{path: "waitingStatuses", err: err}
+    }
+    instance.activity = activity
+    queries, err := newQueries(cfg, instance, valueOrDefault(spec.JQL, defaultJQL), spec.Queries)
+    if err != nil {
+        return instance, err
+    }
+    instance.queries = queries
+    return instance, nil
+}
+
+// expandEnvReferences replaces the ${NAME} references with the JSON-escaped values of
+// the envs, so secrets don't have to be written into the config file
+func expandEnvReferences(data []byte) ([]byte, error) {
+    var err error
+    expanded := envReferencePattern.ReplaceAllFunc(data, func(reference []byte) []byte {
+        name := string(envReferencePattern.FindSubmatch(reference)[1])
+        value, ok := os.LookupEnv(name)
+        if !ok {
+            if err == nil {
+                err = fmt.Errorf("%d: env %s is not set", lineAt(data, int64(bytes.Index(data, reference))), name)
+            }
+            return reference
+        }
+        escaped, _ := json.Marshal(value)
+        return escaped[1 : len(escaped)-1]
+    })
+    return expanded, err
+}
+
+// jsonLines maps the paths of the values in the JSON document, like instances[0].queries.bugs,
+// to their line numbers
+func jsonLines(data []byte) map[string]int {
+    lines := make(map[string]int)
+    dec := json.NewDecoder(bytes.NewReader(data))
+    var walk func(path string) error
+    walk = func(path string) error {
+        if path != "" {
+            lines[path] = lineAt(data, skipSeparators(data, dec.InputOffset()))
+        }
+        token, err := dec.Token()
+        if err != nil {
+            return err
+        }
+        switch token {
+        case json.Delim('{'):
+            for dec.More() {
+                key, err := dec.Token()
+                if err != nil {
+                    return err
+                }
+                child := fmt.Sprint(key)
+                if path != "" {
+                    child = path + "." + child
+                }
+                if err := walk(child); err != nil {
+                    return err
+                }
+            }
+            _, err = dec.Token()
+        case json.Delim('['):
+            for i := 0; dec.More(); i++ {
+                if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+                    return err
+                }
+            }
+            _, err = dec.Token()
+        }
+        return err
+    }
+    // The document is already decoded, so it is valid
+    _ = walk("")
+    return lines
+}
+
+// fragmentError is an error decoding a fragment of a JSON document by a json.Unmarshaler,
+// with the offset of the error in the fragment
+type fragmentError struct {
+    fragment []byte
+    offset   int64
+    err      error
+}
+
+func (e *fragmentError) Error() string {
+    return e.err.Error()
+}
+
+func (e *fragmentError) Unwrap() error {
+    return e.err
+}
+
+// decodeErrorLine returns the line of the value that failed to decode
+func decodeErrorLine(data []byte, dec *json.Decoder, err error) int {
+    return lineAt(data, decodeErrorOffset(data, dec, err))
+}
+
+// decodeErrorOffset returns the offset of the value that failed to decode
+func decodeErrorOffset(data []byte, dec *json.Decoder, err error) int64 {
+    var fragmentErr *fragmentError
+    if errors.As(err, &fragmentErr) {
+        // The decoder passes the fragment as it is in the document
+        if start := bytes.Index(data, fragmentErr.fragment); start >= 0 {
+            return int64(start) + fragmentErr.offset
+        }
+    }
+    var syntaxErr *json.SyntaxError
+    if errors.As(err, &syntaxErr) {
+        return syntaxErr.Offset
+    }
+    var typeErr *json.UnmarshalTypeError
+    if errors.As(err, &typeErr) {
+        return typeErr.Offset
+    }
+    // The decoder only reports the name of an unknown field, so look up its first key
+    if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+        if key := regexp.MustCompile(regexp.QuoteMeta(field) + `\s*:`).FindIndex(data); key != nil {
+            return int64(key[0])
+        }
+    }
+    return dec.InputOffset()
+}
+
+// parentPath returns the path of the parent of the value at the path
+func parentPath(path string) string {
+    i := strings.LastIndexAny(path, ".[")
+    if i < 0 {
+        return ""
+    }
+    return path[:i]
+}
+
+// skipSeparators returns the offset of the first byte at or after offset that is not whitespace, ',' or ':'
+func skipSeparators(data []byte, offset int64) int64 {
+    for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+        offset++
+    }
+    return offset
+}
+
+// lineAt returns the 1-based line number of the offset in data
+func lineAt(data []byte, offset int64) int {
+    if offset > int64(len(data)) {
+        offset = int64(len(data))
+    }
+    return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+    if value == "" {
+        return defaultValue
+    }
+    return value
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// configEnvs are the envs that override the config file
+var configEnvs = []string{
+    "LISTEN", "CACHE_DIR", "DATA_REFRESH_PERIOD", "DATA_RETRY_PERIOD", "FULL_SYNC_PERIOD", "SYNC_OVERLAP",
+    "JIRA_REQUEST_TIMEOUT", "JIRA_RETRY_BACKOFF", "READINESS_MAX_AGE", "STALE_THRESHOLDS",
+    "ANALYZE_PERIOD_DAYS", "JIRA_PAGE_SIZE", "JIRA_CHANGELOG_CONCURRENCY", "JIRA_MAX_RETRIES",
+    "JIRA_REQUESTS_PER_SECOND", "JIRA_INSTANCES", "JIRA_URL", "JIRA_USER", "JIRA_API_TOKEN", "JIRA_AUTH",
+    "JIRA_FLAVOR", "JIRA_SEARCH_API", "PROJECTS", "JQL", "QUERIES", "STAGES", "ACTIVE_STATUSES", "WAITING_STATUSES",
+}
+
+// writeConfig clears the config envs but the required LISTEN, sets the given ones and
+// writes the config file
+func writeConfig(t *testing.T, content string, envs map[string]string) string {
+    t.Helper()
+    for _, name := range configEnvs {
+        t.Setenv(name, "")
+    }
+    t.Setenv("LISTEN", ":9090")
+    for name, value := range envs {
+        t.Setenv(name, value)
+    }
+    path := filepath.Join(t.TempDir(), "config.json")
+    if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestLoadConfigErrorLines(t *testing.T) {
+    tests := []struct {
+        name    string
+        content string
+        want    string
+    }{
+        {
+            name: "syntax error",
+            content: `{
+  "listen": ":9090",,
+  "instances": []
+}`,
+            want: ":2: invalid character ','",
+        },
+        {
+            name: "wrong type",
+            content: `{
+  "listen": ":9090",
+  "pageSize": "100"
+}`,
+            want: ":3: json: cannot unmarshal string",
+        },
+        {
+            name: "unknown field",
+            content: `{
+  "listen": ":9090",
+  "refresh": "5m"
+}`,
+            want: `:3: json: unknown field "refresh"`,
+        },
+        {
+            name: "wrong type in a query",
+            content: `{
+  "instances": [
+    {
+      "name": "a", "url": "http://jira", "user": "u", "apiToken": "t", "projects": "A",
+      "queries": {
+        "support": {
+          "jql": "project = SUP",
+          "refreshPeriod": 5
+        }
+      }
+    }
+  ]
+}`,
+            want: ":8: json: cannot unmarshal number",
+        },
+        {
+            name: "unknown field in a query named like an instance field",
+            content: `{
+  "instances": [
+    {
+      "name": "a", "url": "http://jira", "user": "alice", "apiToken": "t",
+      "queries": {
+        "support": {
+          "jql": "project = SUP",
+          "user": "bob"
+        }
+      }
+    }
+  ]
+}`,
+            want: `:8: json: unknown field "user"`,
+        },
+        {
+            name: "syntax error in a query",
+            content: `{
+  "instances": [
+    {
+      "name": "a", "url": "http://jira", "user": "u", "apiToken": "t",
+      "queries": {
+        "support": {
+          "jql": "project = SUP",,
+        }
+      }
+    }
+  ]
+}`,
+            want: ":7: invalid character ','",
+        },
+        {
+            name: "invalid setting in a query",
+            content: `{
+  "instances": [
+    {
+      "name": "a", "url": "http://jira", "user": "u", "apiToken": "t",
+      "queries": {
+        "support": {
+          "jql": "project = SUP",
+          "refreshPeriod": "-1m"
+        }
+      }
+    }
+  ]
+}`,
+            want: ":8: instances[0].queries.support.refreshPeriod: must be positive",
+        },
+        {
+            name: "missing setting of an instance",
+            content: `{
+  "instances": [
+    {
+      "name": "a",
+      "apiToken": "t"
+    }
+  ]
+}`,
+            want: ":3: instances[0].url: is empty",
+        },
+        {
+            name: "unset env reference",
+            content: `{
+  "instances": [
+    {
+      "name": "a", "url": "http://jira",
+      "apiToken": "${JIRA_EXPORTER_TEST_UNSET}"
+    }
+  ]
+}`,
+            want: ":5: env JIRA_EXPORTER_TEST_UNSET is not set",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := writeConfig(t, tt.content, nil)
+            _, err := loadConfig(path)
+            if err == nil {
+                t.Fatal("loadConfig() succeeded, want an error")
+            }
+            if !strings.HasPrefix(err.Error(), path+tt.want) {
+                t.Errorf("loadConfig() error = %q, want prefix %q", err, path+tt.want)
+            }
+        })
+    }
+}
+
+func TestLoadConfigEnvReferences(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+    }{
+        {"plain", "secret"},
+        {"quotes and backslashes", `se"cr\et`},
+        {"control characters", "se\ncr\tet"},
+        {"empty", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := writeConfig(t, `{"instances": [{"name": "a", "url": "http://jira", "projects": "A", "auth": "bearer", "apiToken": "x${JIRA_EXPORTER_TEST_TOKEN}"}]}`,
+                map[string]string{"JIRA_EXPORTER_TEST_TOKEN": tt.value})
+            cfg, err := loadConfig(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := cfg.instances[0].jiraAPIToken; got != "x"+tt.value {
+                t.Errorf("apiToken = %q, want %q", got, "x"+tt.value)
+            }
+        })
+    }
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+    const file = `{
+  "dataRefreshPeriod": "10m",
+  "analyzePeriodDays": 30,
+  "instances": [
+    {"name": "a", "url": "http://file", "user": "u", "apiToken": "t", "projects": "A", "jql": "project = FILE"}
+  ]
+}`
+    tests := []struct {
+        name    string
+        content string
+        envs    map[string]string
+        check   func(t *testing.T, cfg config)
+        wantErr string
+    }{
+        {
+            name:    "defaults",
+            content: `{}`,
+            envs:    map[string]string{"JIRA_URL": "http://env", "JIRA_USER": "u", "JIRA_API_TOKEN": "t", "PROJECTS": "A"},
+            check: func(t *testing.T, cfg config) {
+                if cfg.dataRefreshPeriod != 5*time.Minute || cfg.analyzePeriodDays != 90 {
+                    t.Errorf("dataRefreshPeriod, analyzePeriodDays = %s, %d, want 5m, 90", cfg.dataRefreshPeriod, cfg.analyzePeriodDays)
+                }
+                if len(cfg.instances) != 1 || cfg.instances[0].name != defaultInstanceName || cfg.instances[0].jiraURL != "http://env" {
+                    t.Errorf("instances = %+v, want the default instance from the envs", cfg.instances)
+                }
+                if jql := cfg.instances[0].queries[0].jql; jql != "updated >= -90d AND project in (A)" {
+                    t.Errorf("jql = %q, want the default JQL", jql)
+                }
+            },
+        },
+        {
+            name:    "file over defaults",
+            content: file,
+            check: func(t *testing.T, cfg config) {
+                if cfg.dataRefreshPeriod != 10*time.Minute || cfg.analyzePeriodDays != 30 {
+                    t.Errorf("dataRefreshPeriod, analyzePeriodDays = %s, %d, want 10m, 30", cfg.dataRefreshPeriod, cfg.analyzePeriodDays)
+                }
+                if cfg.dataRetryPeriod != time.Minute {
+                    t.Errorf("dataRetryPeriod = %s, want the default 1m", cfg.dataRetryPeriod)
+                }
+                if url := cfg.instances[0].jiraURL; url != "http://file" {
+                    t.Errorf("url = %q, want http://file", url)
+                }
+            },
+        },
+        {
+            name:    "envs over file",
+            content: file,
+            envs:    map[string]string{"DATA_REFRESH_PERIOD": "1m", "JIRA_URL": "http://env", "JQL": "project = ENV"},
+            check: func(t *testing.T, cfg config) {
+                if cfg.dataRefreshPeriod != time.Minute || cfg.analyzePeriodDays != 30 {
+                    t.Errorf("dataRefreshPeriod, analyzePeriodDays = %s, %d, want 1m, 30", cfg.dataRefreshPeriod, cfg.analyzePeriodDays)
+                }
+                instance := cfg.instances[0]
+                if instance.name != "a" || instance.jiraURL != "http://env" || instance.jiraAPIToken != "t" {
+                    t.Errorf("instance = %s %s %s, want a http://env t", instance.name, instance.jiraURL, instance.jiraAPIToken)
+                }
+                if jql := instance.queries[0].jql; jql != "project = ENV" {
+                    t.Errorf("jql = %q, want project = ENV", jql)
+                }
+            },
+        },
+        {
+            name:    "query settings over global ones",
+            content: file,
+            envs:    map[string]string{"QUERIES": `{"support": {"jql": "project = SUP", "refreshPeriod": "1m"}, "bugs": "type = Bug"}`},
+            check: func(t *testing.T, cfg config) {
+                queries := cfg.instances[0].queries
+                if len(queries) != 2 || queries[0].name != "bugs" || queries[1].name != "support" {
+                    t.Fatalf("queries = %+v, want bugs and support", queries)
+                }
+                if queries[0].refreshPeriod != 10*time.Minute || queries[1].refreshPeriod != time.Minute {
+                    t.Errorf("refresh periods = %s, %s, want 10m, 1m", queries[0].refreshPeriod, queries[1].refreshPeriod)
+                }
+            },
+        },
+        {
+            name:    "instances env replaces the file instances",
+            content: file,
+            envs:    map[string]string{"JIRA_INSTANCES": `[{"name": "b", "url": "http://b", "user": "u", "apiToken": "t", "queries": {"all": "project = B"}}]`},
+            check: func(t *testing.T, cfg config) {
+                if len(cfg.instances) != 1 || cfg.instances[0].name != "b" {
+                    t.Errorf("instances = %+v, want only b", cfg.instances)
+                }
+            },
+        },
+        {
+            name:    "instance env with several instances",
+            content: file,
+            envs: map[string]string{
+                "JIRA_INSTANCES": `[{"name": "b", "url": "http://b", "user": "u", "apiToken": "t"}, {"name": "c", "url": "http://c", "user": "u", "apiToken": "t"}]`,
+                "JIRA_URL":       "http://env",
+            },
+            wantErr: "JIRA_URL can't override one of 2 instances",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cfg, err := loadConfig(writeConfig(t, tt.content, tt.envs))
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("loadConfig() error = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            tt.check(t, cfg)
+        })
+    }
+}
+
+func TestLoadConfigEnvErrors(t *testing.T) {
+    const file = `{
+  "dataRefreshPeriod": "5m",
+  "instances": [
+    {
+      "name": "a", "url": "http://file", "user": "u", "apiToken": "t", "projects": "A",
+      "queries": {"support": {"jql": "project = SUP", "refreshPeriod": "1m"}}
+    }
+  ]
+}`
+    tests := []struct {
+        name    string
+        content string
+        envs    map[string]string
+        want    string
+    }{
+        {
+            name:    "global setting",
+            content: file,
+            envs:    map[string]string{"DATA_REFRESH_PERIOD": "bogus"},
+            want:    `DATA_REFRESH_PERIOD: dataRefreshPeriod: time: invalid duration "bogus"`,
+        },
+        {
+            name:    "instance setting",
+            content: file,
+            envs:    map[string]string{"JIRA_AUTH": "magic"},
+            want:    `JIRA_AUTH: instances[0].auth: unknown auth "magic"`,
+        },
+        {
+            name:    "query of the queries env",
+            content: file,
+            envs:    map[string]string{"QUERIES": `{"support": {"jql": "project = SUP", "refreshPeriod": "-1m"}}`},
+            want:    "QUERIES: instances[0].queries.support.refreshPeriod: must be positive",
+        },
+        {
+            name:    "instance of the instances env",
+            content: file,
+            envs:    map[string]string{"JIRA_INSTANCES": `[{"name": "b", "apiToken": "t"}]`},
+            want:    "JIRA_INSTANCES: instances[0].url: is empty",
+        },
+        {
+            name:    "instance created from the envs",
+            content: `{"instances": []}`,
+            envs:    map[string]string{"JIRA_API_TOKEN": "t"},
+            want:    "instances[0].url: is empty",
+        },
+        {
+            name:    "default query of the JQL env",
+            content: `{"instances": []}`,
+            envs:    map[string]string{"JIRA_URL": "http://env", "JIRA_USER": "u", "JIRA_API_TOKEN": "t", "JQL": "project in ({{.Projects}})"},
+            want:    "JQL: instances[0].queries.default.jql: JQL uses .Projects but the projects are empty",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := loadConfig(writeConfig(t, tt.content, tt.envs))
+            if err == nil || err.Error() != tt.want {
+                t.Errorf("loadConfig() error = %v, want %q", err, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// eventCounter counts changelog events, like completions, into monotonic counters that
+// survive refreshes. Every event is counted once, when it is first seen, and only when it
+// happened after the counter started, so increase() over the counters gives the number
+// of events in any period since. Every counter is served as 0 in the first snapshot it
+// is part of, so increase() sees its first events too.
+type eventCounter struct {
+    start       time.Time
+    windowStart time.Time
+    // seen is the time of every counted event by its key
+    seen   map[string]time.Time
+    counts map[string]*eventSeries
+}
+
+// eventSeries is one counter of an eventCounter
+type eventSeries struct {
+    desc        *prometheus.Desc
+    labelValues []string
+    value       float64
+    // published is set once the counter was added to a snapshot
+    published bool
+}
+
+func newEventCounter(start time.Time) *eventCounter {
+    return &eventCounter{
+        start:  start,
+        seen:   make(map[string]time.Time),
+        counts: make(map[string]*eventSeries),
+    }
+}
+
+// prune forgets the events before the start of the analysis window. Events that old
+// are never counted, so an issue coming back into the window isn't counted twice.
+func (c *eventCounter) prune(windowStart time.Time) {
+    c.windowStart = windowStart
+    for key, at := range c.seen {
+        if at.Before(windowStart) {
+            delete(c.seen, key)
+        }
+    }
+}
+
+// declare creates the counter identified by desc and labelValues, if it doesn't exist
+// yet, so it is served before its first event
+func (c *eventCounter) declare(desc *prometheus.Desc, labelValues ...string) *eventSeries {
+    key := seriesKey(desc, labelValues)
+    series, ok := c.counts[key]
+    if !ok {
+        series = &eventSeries{desc: desc, labelValues: labelValues}
+        c.counts[key] = series
+    }
+    return series
+}
+
+// count increments the counter identified by desc and labelValues for the event with
+// the key that happened at the time, unless it was counted already. The counter is
+// declared even for events that aren't counted.
+func (c *eventCounter) count(key string, at time.Time, desc *prometheus.Desc, labelValues ...string) {
+    series := c.declare(desc, labelValues...)
+    if at.Before(c.start) || at.Before(c.windowStart) {
+        return
+    }
+    if _, ok := c.seen[key]; ok {
+        return
+    }
+    c.seen[key] = at
+    series.value++
+}
+
+// addTo adds the counters to the snapshot, the new ones as 0 and the others with their
+// value, so the first snapshot of a counter is its baseline
+func (c *eventCounter) addTo(b *snapshotBuilder) {
+    for _, series := range c.counts {
+        value := series.value
+        if !series.published {
+            value = 0
+            series.published = true
+        }
+        b.addCounter(series.desc, value, series.labelValues...)
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshotValue returns the value of the gauge or counter of the builder, or false when it is missing
+func snapshotValue(b *snapshotBuilder, desc *prometheus.Desc, labelValues ...string) (float64, bool) {
+    value, ok := b.values[seriesKey(desc, labelValues)]
+    if !ok {
+        return 0, false
+    }
+    return value.value, true
+}
+
+func TestEventCounterSeries(t *testing.T) {
+    day := func(d int) time.Time { return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC) }
+    bug := testWorkflowIssue("A-2", day(5), statusChange{day(5), "Done"})
+    bug.Fields.IssueType.Name = "Bug"
+    steps := []struct {
+        name   string
+        issues []JiraIssue
+        // want is the value of jira_issues_resolved_total by issue type, -1 for a missing series
+        want map[string]float64
+    }{
+        {
+            name:   "issues without completions start at 0",
+            issues: []JiraIssue{testWorkflowIssue("A-1", day(1))},
+            want:   map[string]float64{"Task": 0, "Bug": -1},
+        },
+        {
+            name:   "completion of a known type",
+            issues: []JiraIssue{testWorkflowIssue("A-1", day(1), statusChange{day(4), "Done"})},
+            want:   map[string]float64{"Task": 1, "Bug": -1},
+        },
+        {
+            name:   "completion of a new type is served as 0 first",
+            issues: []JiraIssue{testWorkflowIssue("A-1", day(1), statusChange{day(4), "Done"}), bug},
+            want:   map[string]float64{"Task": 1, "Bug": 0},
+        },
+        {
+            name:   "and counted in the next snapshot",
+            issues: []JiraIssue{testWorkflowIssue("A-1", day(1), statusChange{day(4), "Done"}), bug},
+            want:   map[string]float64{"Task": 1, "Bug": 1},
+        },
+    }
+    ctx := issueContext{instance: "default", query: "default", categories: testStatusCategories(), events: newEventCounter(day(3)), now: day(6)}
+    for _, step := range steps {
+        t.Run(step.name, func(t *testing.T) {
+            b := newSnapshotBuilder()
+            for _, issue := range step.issues {
+                issue.Fields.Project.Key = "A"
+                if issue.Fields.IssueType.Name == "" {
+                    issue.Fields.IssueType.Name = "Task"
+                }
+                countCompletions(ctx, issue)
+                countTransitions(ctx, issue)
+                calculateReopens(b, ctx, issue)
+            }
+            ctx.events.addTo(b)
+            for issueType, want := range step.want {
+                got, ok := snapshotValue(b, jiraIssuesResolved, "default", "default", "A", issueType)
+                if !ok {
+                    got = -1
+                }
+                if got != want {
+                    t.Errorf("jira_issues_resolved_total{issueType=%q} = %v, want %v", issueType, got, want)
+                }
+            }
+            if _, ok := snapshotValue(b, jiraIssueReopensTotal, "default", "default", "A", "Task"); !ok {
+                t.Error("jira_issue_reopens_total{issueType=\"Task\"} is missing")
+            }
+        })
+    }
+}
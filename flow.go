@@ -0,0 +1,442 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Workflow stages the statuses are mapped to
+const (
+    stageTodo       = "todo"
+    stageInProgress = "inProgress"
+    stageDone       = "done"
+)
+
+// Kinds of time spent in a status for flow efficiency
+const (
+    activityActive  = "active"
+    activityWaiting = "waiting"
+)
+
+var (
+    jiraIssueLeadTime = prometheus.NewDesc(
+        "jira_issue_lead_time_seconds",
+        "Time from the creation of done issues to their entry into the done stage.",
+        []string{"jira_instance", "query", "project", "priority", "assignee", "issueType"},
+        nil,
+    )
+    jiraIssueCycleTime = prometheus.NewDesc(
+        "jira_issue_cycle_time_seconds",
+        "Time from the first entry of done issues into the in progress stage to their entry into the done stage.",
+        []string{"jira_instance", "query", "project", "priority", "assignee", "issueType"},
+        nil,
+    )
+    jiraIssuesResolved = prometheus.NewDesc(
+        "jira_issues_resolved_total",
+        "Number of issues completed, by a transition into the done status category or by setting the resolution, since the exporter started.",
+        []string{"jira_instance", "query", "project", "issueType"},
+        nil,
+    )
+    jiraIssueTransitions = prometheus.NewDesc(
+        "jira_issue_transitions_total",
+        "Number of status transitions of issues since the exporter started.",
+        []string{"jira_instance", "query", "project", "from_status", "to_status", "issueType"},
+        nil,
+    )
+    jiraIssueReopensTotal = prometheus.NewDesc(
+        "jira_issue_reopens_total",
+        "Number of transitions of issues from the done status category back to another one since the exporter started.",
+        []string{"jira_instance", "query", "project", "issueType"},
+        nil,
+    )
+    jiraIssueReopens = prometheus.NewDesc(
+        "jira_issue_reopens",
+        "Number of times each issue was reopened.",
+        []string{"jira_instance", "query", "project", "issueType"},
+        nil,
+    )
+    jiraIssuesReopened = prometheus.NewDesc(
+        "jira_issues_reopened",
+        "Number of issues that were reopened and are not done again.",
+        []string{"jira_instance", "query", "project", "issueType"},
+        nil,
+    )
+    reopensBuckets = []float64{0, 1, 2, 3, 5, 8}
+
+    jiraIssueFlowEfficiency = prometheus.NewDesc(
+        "jira_issue_flow_efficiency",
+        "Share of active time in the active and waiting time of the cycle of done issues.",
+        []string{"jira_instance", "query", "project", "issueType"},
+        nil,
+    )
+    flowEfficiencyBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+
+    jiraIssueWIPAgeSinceCreated = prometheus.NewDesc(
+        "jira_issue_wip_age_since_created_seconds",
+        "Time since the creation of issues in a status of the In Progress category.",
+        []string{"jira_instance", "query", "project", "status", "issueType"},
+        nil,
+    )
+    jiraIssueWIPAgeSinceStarted = prometheus.NewDesc(
+        "jira_issue_wip_age_since_started_seconds",
+        "Time since the first entry into the in progress stage of issues in a status of the In Progress category.",
+        []string{"jira_instance", "query", "project", "status", "issueType"},
+        nil,
+    )
+    jiraIssueWIPOldestAge = prometheus.NewDesc(
+        "jira_issue_wip_oldest_age_seconds",
+        "Time since the first entry into the in progress stage of the oldest issue in a status of the In Progress category.",
+        []string{"jira_instance", "query", "project", "status", "issueType"},
+        nil,
+    )
+)
+
+// stageMapping maps the lowercased names of workflow statuses to stages
+type stageMapping map[string]string
+
+// newStageMapping validates the configured status to stage mapping
+func newStageMapping(spec map[string]string) (stageMapping, error) {
+    stages := make(stageMapping, len(spec))
+    for status, stage := range spec {
+        if stage != stageTodo && stage != stageInProgress && stage != stageDone {
+            return nil, &configError{path: status, err: fmt.Errorf("unknown stage %q, must be %s, %s or %s", stage, stageTodo, stageInProgress, stageDone)}
+        }
+        stages[strings.ToLower(status)] = stage
+    }
+    if len(stages) != len(spec) {
+        return nil, errors.New("a status is mapped more than once")
+    }
+    return stages, nil
+}
+
+// stage returns the stage of the status: the configured one or, for statuses missing
+// from the mapping, the one of the key of its status category
+func (m stageMapping) stage(name string, categoryKey string) string {
+    if stage, ok := m[strings.ToLower(name)]; ok {
+        return stage
+    }
+    switch categoryKey {
+    case "new":
+        return stageTodo
+    case "indeterminate":
+        return stageInProgress
+    case "done":
+        return stageDone
+    }
+    return ""
+}
+
+// statusActivity maps the lowercased names of workflow statuses to whether the time
+// spent in them is active or waiting
+type statusActivity map[string]string
+
+// newStatusActivity validates the configured active and waiting statuses
+func newStatusActivity(active []string, waiting []string) (statusActivity, error) {
+    activity := make(statusActivity, len(active)+len(waiting))
+    for _, status := range active {
+        activity[strings.ToLower(strings.TrimSpace(status))] = activityActive
+    }
+    for _, status := range waiting {
+        status = strings.ToLower(strings.TrimSpace(status))
+        if activity[status] == activityActive {
+            return nil, fmt.Errorf("status %q is both active and waiting", status)
+        }
+        activity[status] = activityWaiting
+    }
+    return activity, nil
+}
+
+// activityOf returns whether the time in the status is active or waiting: the configured
+// kind or, for statuses missing from the configuration, active in the in progress stage
+// and waiting in the todo stage. Time in done statuses is neither.
+func (ctx issueContext) activityOf(status statusRef) string {
+    if activity, ok := ctx.activity[strings.ToLower(status.name)]; ok {
+        return activity
+    }
+    switch ctx.stageOf(status) {
+    case stageInProgress:
+        return activityActive
+    case stageTodo:
+        return activityWaiting
+    }
+    return ""
+}
+
+// statusTransition is a status change of an issue
+type statusTransition struct {
+    historyID string
+    at        time.Time
+    from      statusRef
+    to        statusRef
+}
+
+// statusTransitions returns the status changes of the issue from the oldest to the newest
+func statusTransitions(issue JiraIssue) []statusTransition {
+    var transitions []statusTransition
+    for _, history := range issue.Changelog.Histories {
+        for _, item := range history.Items {
+            if item.Field != "status" {
+                continue
+            }
+            transitions = append(transitions, statusTransition{
+                historyID: history.ID,
+                at:        mustTimeParse(history.Created),
+                from:      statusRef{id: item.From, name: stringValue(item.FromString)},
+                to:        statusRef{id: item.To, name: stringValue(item.ToString)},
+            })
+        }
+    }
+    return transitions
+}
+
+// stageOf returns the stage of the status of a changelog entry
+func (ctx issueContext) stageOf(status statusRef) string {
+    return ctx.stages.stage(status.name, ctx.categories.lookup(status.id, status.name).key)
+}
+
+// currentStage returns the stage of the current status of the issue
+func (ctx issueContext) currentStage(issue JiraIssue) string {
+    return ctx.stages.stage(issue.Fields.Status.Name, issue.Fields.Status.StatusCategory.Key)
+}
+
+// flowTimes are the points in time the lead and cycle times of an issue are measured between
+type flowTimes struct {
+    created time.Time
+    // started is the first entry into the in progress stage, zero when the issue never was in progress
+    started time.Time
+    // done is the last entry into the done stage, zero when the issue is not done
+    done time.Time
+}
+
+// issueFlowTimes reconstructs the flow times of the issue from its changelog
+func issueFlowTimes(ctx issueContext, issue JiraIssue, transitions []statusTransition) flowTimes {
+    times := flowTimes{created: mustTimeParse(issue.Fields.Created)}
+    stage := ctx.currentStage(issue)
+    if len(transitions) > 0 {
+        stage = ctx.stageOf(transitions[0].from)
+    }
+    if stage == stageInProgress {
+        times.started = times.created
+    }
+    if stage == stageDone {
+        times.done = times.created
+    }
+    for _, transition := range transitions {
+        next := ctx.stageOf(transition.to)
+        if next == stageInProgress && times.started.IsZero() {
+            times.started = transition.at
+        }
+        if next == stageDone && stage != stageDone {
+            times.done = transition.at
+        }
+        stage = next
+    }
+    // The current status is authoritative when the changelog and the statuses disagree
+    if ctx.currentStage(issue) != stageDone {
+        times.done = time.Time{}
+    }
+    return times
+}
+
+// calculateFlowTimes observes the lead time and the cycle time of done issues and the
+// age of the issues in progress
+func calculateFlowTimes(b *snapshotBuilder, ctx issueContext, issue JiraIssue) {
+    times := issueFlowTimes(ctx, issue, statusTransitions(issue))
+    if issue.Fields.Status.StatusCategory.Key == "indeterminate" {
+        calculateWIPAge(b, ctx, issue, times)
+    }
+    if times.done.IsZero() {
+        return
+    }
+    labelValues := []string{
+        ctx.instance,
+        ctx.query,
+        issue.Fields.Project.Key,
+        issue.Fields.Priority.Name,
+        issue.Fields.Assignee.EmailAddress,
+        issue.Fields.IssueType.Name,
+    }
+    b.observe(jiraIssueLeadTime, timeInStatusBuckets, times.done.Sub(times.created).Seconds(), labelValues...)
+    if !times.started.IsZero() && !times.started.After(times.done) {
+        b.observe(jiraIssueCycleTime, timeInStatusBuckets, times.done.Sub(times.started).Seconds(), labelValues...)
+        calculateFlowEfficiency(b, ctx, issue, times)
+    }
+}
+
+// calculateFlowEfficiency observes the share of active time in the active and waiting
+// time of the cycle of the done issue, from its first entry into the in progress stage
+// to its entry into the done stage
+func calculateFlowEfficiency(b *snapshotBuilder, ctx issueContext, issue JiraIssue, times flowTimes) {
+    spent := make(map[string]time.Duration)
+    transitions := statusTransitions(issue)
+    status := statusRef{name: issue.Fields.Status.Name}
+    if len(transitions) > 0 {
+        status = transitions[0].from
+    }
+    intervalStart := times.created
+    for i := 0; i <= len(transitions); i++ {
+        intervalEnd := times.done
+        if i < len(transitions) {
+            intervalEnd = transitions[i].at
+        }
+        // Only the part of the interval within the cycle counts
+        from, to := maxTime(intervalStart, times.started), minTime(intervalEnd, times.done)
+        if to.After(from) {
+            spent[ctx.activityOf(status)] += to.Sub(from)
+        }
+        if i < len(transitions) {
+            status = transitions[i].to
+            intervalStart = transitions[i].at
+        }
+    }
+    total := spent[activityActive] + spent[activityWaiting]
+    if total <= 0 {
+        return
+    }
+    b.observe(jiraIssueFlowEfficiency, flowEfficiencyBuckets, float64(spent[activityActive])/float64(total),
+        ctx.instance,
+        ctx.query,
+        issue.Fields.Project.Key,
+        issue.Fields.IssueType.Name,
+    )
+}
+
+func maxTime(a, b time.Time) time.Time {
+    if a.After(b) {
+        return a
+    }
+    return b
+}
+
+func minTime(a, b time.Time) time.Time {
+    if a.Before(b) {
+        return a
+    }
+    return b
+}
+
+// calculateWIPAge observes the age of the issue in progress since its creation and since
+// it was first in progress. An issue never in the in progress stage, because its status
+// is mapped to another one, has no age since it was started.
+func calculateWIPAge(b *snapshotBuilder, ctx issueContext, issue JiraIssue, times flowTimes) {
+    labelValues := []string{
+        ctx.instance,
+        ctx.query,
+        issue.Fields.Project.Key,
+        issue.Fields.Status.Name,
+        issue.Fields.IssueType.Name,
+    }
+    b.observe(jiraIssueWIPAgeSinceCreated, timeInStatusBuckets, ctx.now.Sub(times.created).Seconds(), labelValues...)
+    if times.started.IsZero() {
+        return
+    }
+    age := ctx.now.Sub(times.started).Seconds()
+    b.observe(jiraIssueWIPAgeSinceStarted, timeInStatusBuckets, age, labelValues...)
+    b.maxGauge(jiraIssueWIPOldestAge, age, labelValues...)
+}
+
+// completion is a completion of an issue, identified by the changelog entry it started with
+type completion struct {
+    historyID string
+    at        time.Time
+}
+
+// issueCompletions returns the completions of the issue from the oldest to the newest: the
+// moves into the done status category and the settings of the resolution. A move and the
+// setting of the resolution in separate changelog entries are one completion, as the next
+// completion only comes after a reopen, a move out of the done status category or the
+// clearing of the resolution.
+func issueCompletions(ctx issueContext, issue JiraIssue) []completion {
+    var completions []completion
+    completed := false
+    for _, history := range issue.Changelog.Histories {
+        for _, item := range history.Items {
+            completes, reopens := false, false
+            switch item.Field {
+            case "status":
+                from := ctx.categories.lookup(item.From, stringValue(item.FromString)).key
+                to := ctx.categories.lookup(item.To, stringValue(item.ToString)).key
+                completes = to == "done" && from != "done"
+                reopens = from == "done" && to != "done"
+            case "resolution":
+                completes = item.From == "" && item.To != ""
+                reopens = item.From != "" && item.To == ""
+            }
+            if completes && !completed {
+                completions = append(completions, completion{historyID: history.ID, at: mustTimeParse(history.Created)})
+            }
+            if completes || reopens {
+                completed = completes
+            }
+        }
+    }
+    return completions
+}
+
+// countCompletions counts the completions of the issue
+func countCompletions(ctx issueContext, issue JiraIssue) {
+    ctx.events.declare(jiraIssuesResolved, ctx.instance, ctx.query, issue.Fields.Project.Key, issue.Fields.IssueType.Name)
+    for _, c := range issueCompletions(ctx, issue) {
+        ctx.events.count("resolved/"+issue.Key+"/"+c.historyID, c.at, jiraIssuesResolved,
+            ctx.instance,
+            ctx.query,
+            issue.Fields.Project.Key,
+            issue.Fields.IssueType.Name,
+        )
+    }
+}
+
+// countTransitions counts the status transitions of the issue by the statuses they go from and to
+func countTransitions(ctx issueContext, issue JiraIssue) {
+    for _, transition := range statusTransitions(issue) {
+        key := fmt.Sprintf("transition/%s/%s/%s/%s", issue.Key, transition.historyID, transition.from.id, transition.to.id)
+        ctx.events.count(key, transition.at, jiraIssueTransitions,
+            ctx.instance,
+            ctx.query,
+            issue.Fields.Project.Key,
+            transition.from.name,
+            transition.to.name,
+            issue.Fields.IssueType.Name,
+        )
+    }
+}
+
+// calculateReopens counts the transitions of the issue from the done status category
+// back to another one and observes how often the issue was reopened
+func calculateReopens(b *snapshotBuilder, ctx issueContext, issue JiraIssue) {
+    labelValues := []string{
+        ctx.instance,
+        ctx.query,
+        issue.Fields.Project.Key,
+        issue.Fields.IssueType.Name,
+    }
+    ctx.events.declare(jiraIssueReopensTotal, labelValues...)
+    reopens := 0
+    for _, transition := range statusTransitions(issue) {
+        from := ctx.categories.lookup(transition.from.id, transition.from.name).key
+        to := ctx.categories.lookup(transition.to.id, transition.to.name).key
+        if from != "done" || to == "done" {
+            continue
+        }
+        reopens++
+        key := fmt.Sprintf("reopen/%s/%s", issue.Key, transition.historyID)
+        ctx.events.count(key, transition.at, jiraIssueReopensTotal, labelValues...)
+    }
+    b.observe(jiraIssueReopens, reopensBuckets, float64(reopens), labelValues...)
+    reopened := 0.0
+    if reopens > 0 && issue.Fields.Status.StatusCategory.Key != "done" {
+        reopened = 1
+    }
+    // Report every project and issue type, so the count goes down to 0 instead of disappearing
+    b.addGauge(jiraIssuesReopened, reopened, labelValues...)
+}
+
+// stringValue returns the value of a changelog item field that is null for empty values
+func stringValue(v interface{}) string {
+    s, _ := v.(string)
+    return s
+}
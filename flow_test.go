@@ -0,0 +1,204 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestCalculateReopens(t *testing.T) {
+    day := func(d int) time.Time { return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC) }
+    tests := []struct {
+        name         string
+        issue        JiraIssue
+        wantReopened float64
+    }{
+        {"never done", testWorkflowIssue("A-1", day(1), statusChange{day(2), "In Progress"}), 0},
+        {"done", testWorkflowIssue("A-1", day(1), statusChange{day(2), "Done"}), 0},
+        {"reopened", testWorkflowIssue("A-1", day(1), statusChange{day(2), "Done"}, statusChange{day(3), "In Progress"}), 1},
+        {"done again", testWorkflowIssue("A-1", day(1), statusChange{day(2), "Done"}, statusChange{day(3), "In Progress"}, statusChange{day(4), "Done"}), 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ctx := issueContext{instance: "default", query: "default", categories: testStatusCategories(), events: newEventCounter(day(1)), now: day(5)}
+            issue := tt.issue
+            issue.Fields.Project.Key = "A"
+            issue.Fields.IssueType.Name = "Task"
+            b := newSnapshotBuilder()
+            calculateReopens(b, ctx, issue)
+            got, ok := snapshotValue(b, jiraIssuesReopened, "default", "default", "A", "Task")
+            if !ok || got != tt.wantReopened {
+                t.Errorf("jira_issues_reopened = %v (present: %t), want %v", got, ok, tt.wantReopened)
+            }
+        })
+    }
+}
+
+// createdIn makes the issue start in the status instead of To Do, it must have status changes
+func createdIn(issue JiraIssue, status string) JiraIssue {
+    item := &issue.Changelog.Histories[0].Items[0]
+    item.From = testStatuses[status].id
+    item.FromString = status
+    return issue
+}
+
+func TestIssueFlowTimes(t *testing.T) {
+    day := func(d int) time.Time { return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC) }
+    tests := []struct {
+        name        string
+        issue       JiraIssue
+        stages      map[string]string
+        wantStarted time.Time
+        wantDone    time.Time
+    }{
+        {
+            name:  "never started",
+            issue: testWorkflowIssue("A-1", day(1)),
+        },
+        {
+            name:        "done",
+            issue:       testWorkflowIssue("A-1", day(1), statusChange{day(2), "In Progress"}, statusChange{day(4), "Done"}),
+            wantStarted: day(2),
+            wantDone:    day(4),
+        },
+        {
+            name: "reopened and done again",
+            issue: testWorkflowIssue("A-1", day(1),
+                statusChange{day(2), "In Progress"},
+                statusChange{day(3), "Done"},
+                statusChange{day(4), "In Progress"},
+                statusChange{day(6), "Done"},
+            ),
+            wantStarted: day(2),
+            wantDone:    day(6),
+        },
+        {
+            name: "reopened",
+            issue: testWorkflowIssue("A-1", day(1),
+                statusChange{day(2), "In Progress"},
+                statusChange{day(3), "Done"},
+                statusChange{day(4), "In Progress"},
+            ),
+            wantStarted: day(2),
+        },
+        {
+            name:        "created in progress",
+            issue:       createdIn(testWorkflowIssue("A-1", day(1), statusChange{day(3), "Done"}), "In Progress"),
+            wantStarted: day(1),
+            wantDone:    day(3),
+        },
+        {
+            name:        "status mapped to in progress",
+            issue:       testWorkflowIssue("A-1", day(1), statusChange{day(2), "Review"}, statusChange{day(4), "Done"}),
+            stages:      map[string]string{"Review": stageInProgress},
+            wantStarted: day(2),
+            wantDone:    day(4),
+        },
+        {
+            name: "status mapped to done",
+            issue: testWorkflowIssue("A-1", day(1),
+                statusChange{day(2), "In Progress"},
+                statusChange{day(3), "QA"},
+                statusChange{day(5), "Done"},
+            ),
+            stages:      map[string]string{"QA": stageDone},
+            wantStarted: day(2),
+            wantDone:    day(3),
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            stages, err := newStageMapping(tt.stages)
+            if err != nil {
+                t.Fatal(err)
+            }
+            ctx := issueContext{categories: testStatusCategories(), stages: stages}
+            times := issueFlowTimes(ctx, tt.issue, statusTransitions(tt.issue))
+            if !times.created.Equal(day(1)) {
+                t.Errorf("created = %s, want %s", times.created, day(1))
+            }
+            if !times.started.Equal(tt.wantStarted) {
+                t.Errorf("started = %s, want %s", times.started, tt.wantStarted)
+            }
+            if !times.done.Equal(tt.wantDone) {
+                t.Errorf("done = %s, want %s", times.done, tt.wantDone)
+            }
+        })
+    }
+}
+
+func TestCalculateFlowEfficiency(t *testing.T) {
+    day := func(d int) time.Time { return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC) }
+    tests := []struct {
+        name   string
+        issue  JiraIssue
+        stages map[string]string
+        want   float64
+    }{
+        {
+            name: "active and waiting",
+            issue: testWorkflowIssue("A-1", day(1),
+                statusChange{day(2), "In Progress"},
+                statusChange{day(3), "Blocked"},
+                statusChange{day(4), "In Progress"},
+                statusChange{day(6), "Done"},
+            ),
+            want: 0.75,
+        },
+        {
+            name:  "clipped to the cycle",
+            issue: testWorkflowIssue("A-1", day(1), statusChange{day(5), "In Progress"}, statusChange{day(6), "Done"}),
+            want:  1,
+        },
+        {
+            name: "reopened",
+            issue: testWorkflowIssue("A-1", day(1),
+                statusChange{day(3), "In Progress"},
+                statusChange{day(4), "Done"},
+                statusChange{day(5), "To Do"},
+                statusChange{day(7), "In Progress"},
+                statusChange{day(8), "Done"},
+            ),
+            want: 0.5,
+        },
+        {
+            name:  "created in progress",
+            issue: createdIn(testWorkflowIssue("A-1", day(1), statusChange{day(3), "Blocked"}, statusChange{day(4), "Done"}), "In Progress"),
+            want:  2.0 / 3,
+        },
+        {
+            name: "status mapped to in progress",
+            issue: testWorkflowIssue("A-1", day(1),
+                statusChange{day(2), "Review"},
+                statusChange{day(3), "Blocked"},
+                statusChange{day(4), "Done"},
+            ),
+            stages: map[string]string{"Review": stageInProgress},
+            want:   0.5,
+        },
+    }
+    activity, err := newStatusActivity(nil, []string{"Blocked"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            stages, err := newStageMapping(tt.stages)
+            if err != nil {
+                t.Fatal(err)
+            }
+            ctx := issueContext{instance: "default", query: "default", categories: testStatusCategories(), stages: stages, activity: activity}
+            issue := tt.issue
+            issue.Fields.Project.Key = "A"
+            issue.Fields.IssueType.Name = "Task"
+            b := newSnapshotBuilder()
+            calculateFlowEfficiency(b, ctx, issue, issueFlowTimes(ctx, issue, statusTransitions(issue)))
+            histogram, ok := b.histograms[seriesKey(jiraIssueFlowEfficiency, []string{"default", "default", "A", "Task"})]
+            if !ok || histogram.count != 1 {
+                t.Fatal("jira_issue_flow_efficiency has no observation")
+            }
+            if histogram.sum != tt.want {
+                t.Errorf("flow efficiency = %v, want %v", histogram.sum, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    // issueFields is the list of fields requested for every issue
+    issueFields = "created,updated,status,assignee,project,issuetype"
+
+    // flavorAuto detects the Jira flavor from the server info
+    flavorAuto = "auto"
+    // flavorCloud is Jira Cloud, served with REST API v3
+    flavorCloud = "cloud"
+    // flavorServer is Jira Server or Data Center, served with REST API v2
+    flavorServer = "server"
+
+    // searchAPIAuto selects the search API matching the Jira flavor
+    searchAPIAuto = "auto"
+    // searchAPIJQL is the /search/jql endpoint with nextPageToken pagination used by Jira Cloud
+    searchAPIJQL = "jql"
+    // searchAPILegacy is the /search endpoint with startAt pagination used by Jira Server and Data Center
+    searchAPILegacy = "legacy"
+)
+
+// JiraIssue represents the structure of an issue from Jira
+type JiraIssue struct {
+    Key       string        `json:"key"`
+    Changelog JiraChangelog `json:"changelog"`
+    Fields    struct {
+        Created  string `json:"created"`
+        Updated  string `json:"updated"`
+        Priority struct {
+            Name string `json:"name"`
+        } `json:"priority"`
+        Assignee struct {
+            EmailAddress string `json:"emailAddress"`
+        } `json:"assignee"`
+        Status struct {
+            Name           string `json:"name"`
+            StatusCategory struct {
+                Key  string `json:"key"`
+                Name string `json:"name"`
+            } `json:"statusCategory"`
+        } `json:"status"`
+        IssueType struct {
+            Name string `json:"name"`
+        } `json:"issuetype"`
+        Project struct {
+            Key string `json:"key"`
+        } `json:"project"`
+    } `json:"fields"`
+}
+
+// JiraChangelog represents the changelog of an issue. Search results embed only
+// the first MaxResults histories out of Total.
+type JiraChangelog struct {
+    StartAt    int           `json:"startAt"`
+    MaxResults int           `json:"maxResults"`
+    Total      int           `json:"total"`
+    Histories  []JiraHistory `json:"histories"`
+}
+
+// JiraHistory represents one changelog entry of an issue
+type JiraHistory struct {
+    ID      string            `json:"id"`
+    Created string            `json:"created"`
+    Items   []JiraHistoryItem `json:"items"`
+}
+
+// JiraHistoryItem represents a change of one field in a changelog entry
+type JiraHistoryItem struct {
+    Field      string      `json:"field"`
+    From       string      `json:"from"`
+    FromString interface{} `json:"fromString"`
+    To         string      `json:"to"`
+    ToString   interface{} `json:"toString"`
+}
+
+// JiraStatus represents a workflow status from Jira
+type JiraStatus struct {
+    ID             string `json:"id"`
+    Name           string `json:"name"`
+    StatusCategory struct {
+        Key  string `json:"key"`
+        Name string `json:"name"`
+    } `json:"statusCategory"`
+}
+
+// JiraServerInfo represents the server info of a Jira instance
+type JiraServerInfo struct {
+    Version        string `json:"version"`
+    DeploymentType string `json:"deploymentType"`
+}
+
+// jiraClient fetches data from the Jira REST API
+type jiraClient struct {
+    cfg      config
+    instance instanceConfig
+    auth     jiraAuth
+    http     *http.Client
+    limiter  *rateLimiter
+
+    mu     sync.Mutex
+    flavor string
+}
+
+func newJiraClient(cfg config, instance instanceConfig) *jiraClient {
+    httpClient := &http.Client{
+        Timeout:   cfg.requestTimeout,
+        Transport: instrumentedTransport{instance: instance, next: http.DefaultTransport},
+    }
+    return &jiraClient{
+        cfg:      cfg,
+        instance: instance,
+        auth:     newJiraAuth(instance, httpClient),
+        http:     httpClient,
+        limiter:  newRateLimiter(cfg.requestsPerSecond),
+    }
+}
+
+// resolveFlavor returns the configured Jira flavor, probing the server info once when it is set to auto
+func (c *jiraClient) resolveFlavor() (string, error) {
+    if c.instance.flavor != flavorAuto {
+        return c.instance.flavor, nil
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.flavor != "" {
+        return c.flavor, nil
+    }
+    info, err := c.fetchServerInfo()
+    if err != nil {
+        return "", fmt.Errorf("failed to detect the Jira flavor: %w", err)
+    }
+    c.flavor = flavorServer
+    if info.DeploymentType == "Cloud" {
+        c.flavor = flavorCloud
+    }
+    fmt.Printf("Detected Jira %s %s, using the %s flavor\n", info.DeploymentType, info.Version, c.flavor)
+    return c.flavor, nil
+}
+
+// resolveSearchAPI returns the configured search API or the one matching the Jira flavor
+func (c *jiraClient) resolveSearchAPI() (string, error) {
+    if c.instance.searchAPI != searchAPIAuto {
+        return c.instance.searchAPI, nil
+    }
+    flavor, err := c.resolveFlavor()
+    if err != nil {
+        return "", err
+    }
+    if flavor == flavorCloud {
+        return searchAPIJQL, nil
+    }
+    return searchAPILegacy, nil
+}
+
+// apiURL returns the URL of the REST API resource in the API version matching the Jira flavor
+func (c *jiraClient) apiURL(resource string, query url.Values) (string, error) {
+    flavor, err := c.resolveFlavor()
+    if err != nil {
+        return "", err
+    }
+    version := "2"
+    if flavor == flavorCloud {
+        version = "3"
+    }
+    apiURL := fmt.Sprintf("%s/rest/api/%s/%s", c.instance.jiraURL, version, resource)
+    if len(query) > 0 {
+        apiURL += "?" + query.Encode()
+    }
+    return apiURL, nil
+}
+
+// fetchIssues connects to the Jira API and fetches the issues matching the JQL query
+func (c *jiraClient) fetchIssues(jql string) ([]JiraIssue, error) {
+    searchAPI, err := c.resolveSearchAPI()
+    if err != nil {
+        return nil, err
+    }
+    issues := make([]JiraIssue, 0)
+    if searchAPI == searchAPIJQL {
+        nextPageToken := ""
+        for {
+            page, err := c.searchJQL(jql, nextPageToken)
+            if err != nil {
+                return nil, err
+            }
+            issues = append(issues, page.Issues...)
+            if page.IsLast || page.NextPageToken == "" {
+                break
+            }
+            nextPageToken = page.NextPageToken
+        }
+    } else {
+        for {
+            page, err := c.searchLegacy(jql, len(issues))
+            if err != nil {
+                return nil, err
+            }
+            issues = append(issues, page.Issues...)
+            if len(page.Issues) == 0 || len(issues) >= page.Total {
+                break
+            }
+        }
+    }
+    if err := c.completeChangelogs(issues); err != nil {
+        return nil, err
+    }
+    for i := range issues {
+        sortHistories(issues[i].Changelog.Histories)
+    }
+    return issues, nil
+}
+
+// completeChangelogs replaces the truncated changelogs embedded in search results with
+// the full ones, fetching up to cfg.changelogConcurrency changelogs at once
+func (c *jiraClient) completeChangelogs(issues []JiraIssue) error {
+    var (
+        wg       sync.WaitGroup
+        mu       sync.Mutex
+        firstErr error
+    )
+    slots := make(chan struct{}, c.cfg.changelogConcurrency)
+    for i := range issues {
+        changelog := &issues[i].Changelog
+        if len(changelog.Histories) >= changelog.Total {
+            continue
+        }
+        wg.Add(1)
+        slots <- struct{}{}
+        go func(issue *JiraIssue) {
+            defer wg.Done()
+            defer func() { <-slots }()
+            jiraChangelogFetches.WithLabelValues(c.instance.name).Inc()
+            histories, err := c.fetchChangelog(issue.Key)
+            mu.Lock()
+            defer mu.Unlock()
+            if err != nil {
+                if firstErr == nil {
+                    firstErr = fmt.Errorf("failed to fetch the changelog of %s: %w", issue.Key, err)
+                }
+                return
+            }
+            issue.Changelog.Histories = histories
+            issue.Changelog.StartAt = 0
+            issue.Changelog.MaxResults = len(histories)
+            issue.Changelog.Total = len(histories)
+        }(&issues[i])
+    }
+    wg.Wait()
+    return firstErr
+}
+
+// fetchChangelog fetches the full changelog of the issue. Jira Cloud pages it through
+// the changelog resource, Jira Server returns it whole with the issue.
+func (c *jiraClient) fetchChangelog(key string) ([]JiraHistory, error) {
+    flavor, err := c.resolveFlavor()
+    if err != nil {
+        return nil, err
+    }
+    if flavor != flavorCloud {
+        apiURL, err := c.apiURL("issue/"+url.PathEscape(key), url.Values{"expand": {"changelog"}, "fields": {"status"}})
+        if err != nil {
+            return nil, err
+        }
+        var issue JiraIssue
+        if err := c.getJSON(apiURL, &issue); err != nil {
+            return nil, err
+        }
+        return issue.Changelog.Histories, nil
+    }
+    histories := make([]JiraHistory, 0)
+    for {
+        query := url.Values{
+            "startAt":    {strconv.Itoa(len(histories))},
+            "maxResults": {"100"},
+        }
+        apiURL, err := c.apiURL("issue/"+url.PathEscape(key)+"/changelog", query)
+        if err != nil {
+            return nil, err
+        }
+        var page struct {
+            Values []JiraHistory `json:"values"`
+            Total  int           `json:"total"`
+            IsLast bool          `json:"isLast"`
+        }
+        if err := c.getJSON(apiURL, &page); err != nil {
+            return nil, err
+        }
+        histories = append(histories, page.Values...)
+        if page.IsLast || len(page.Values) == 0 || len(histories) >= page.Total {
+            break
+        }
+    }
+    return histories, nil
+}
+
+// sortHistories orders the changelog entries from the oldest to the newest. Search results
+// list them newest first, the changelog resource oldest first.
+func sortHistories(histories []JiraHistory) {
+    sort.SliceStable(histories, func(i, j int) bool {
+        return mustTimeParse(histories[i].Created).Before(mustTimeParse(histories[j].Created))
+    })
+}
+
+type searchJQLPage struct {
+    Issues        []JiraIssue `json:"issues"`
+    NextPageToken string      `json:"nextPageToken"`
+    IsLast        bool        `json:"isLast"`
+}
+
+// searchJQL fetches one page of issues from the /search/jql endpoint
+func (c *jiraClient) searchJQL(jql string, nextPageToken string) (searchJQLPage, error) {
+    fmt.Printf("Fetching Jira data page %q\n", nextPageToken)
+    query := url.Values{
+        "jql":        {jql},
+        "expand":     {"changelog"},
+        "fields":     {issueFields},
+        "maxResults": {strconv.Itoa(c.cfg.pageSize)},
+    }
+    if nextPageToken != "" {
+        query.Set("nextPageToken", nextPageToken)
+    }
+    var page searchJQLPage
+    apiURL, err := c.apiURL("search/jql", query)
+    if err != nil {
+        return page, err
+    }
+    err = c.getJSON(apiURL, &page)
+    return page, err
+}
+
+type searchLegacyPage struct {
+    Issues []JiraIssue `json:"issues"`
+    Total  int         `json:"total"`
+}
+
+// searchLegacy fetches one page of issues from the /search endpoint
+func (c *jiraClient) searchLegacy(jql string, startAt int) (searchLegacyPage, error) {
+    fmt.Printf("Fetching Jira data starting from %d\n", startAt)
+    query := url.Values{
+        "jql":        {jql},
+        "expand":     {"changelog"},
+        "fields":     {issueFields},
+        "startAt":    {strconv.Itoa(startAt)},
+        "maxResults": {strconv.Itoa(c.cfg.pageSize)},
+    }
+    var page searchLegacyPage
+    apiURL, err := c.apiURL("search", query)
+    if err != nil {
+        return page, err
+    }
+    err = c.getJSON(apiURL, &page)
+    return page, err
+}
+
+// fetchStatuses fetches the workflow statuses to resolve status categories of the changelog entries
+func (c *jiraClient) fetchStatuses() ([]JiraStatus, error) {
+    var statuses []JiraStatus
+    apiURL, err := c.apiURL("status", nil)
+    if err != nil {
+        return nil, err
+    }
+    err = c.getJSON(apiURL, &statuses)
+    return statuses, err
+}
+
+// fetchServerInfo fetches the server info. It uses API v2, which both Jira Cloud and Jira Server provide.
+func (c *jiraClient) fetchServerInfo() (JiraServerInfo, error) {
+    var info JiraServerInfo
+    err := c.getJSON(fmt.Sprintf("%s/rest/api/2/serverInfo", c.instance.jiraURL), &info)
+    return info, err
+}
+
+// checkServerInfo fetches the server info with a single request, bypassing the retries
+// and the rate limiter, so a health check neither blocks on an unreachable Jira nor uses
+// up the request budget of the refreshes
+func (c *jiraClient) checkServerInfo(ctx context.Context) error {
+    req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/rest/api/2/serverInfo", c.instance.jiraURL), nil)
+    if err != nil {
+        return err
+    }
+    if err := c.auth.authenticate(req); err != nil {
+        return err
+    }
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, resp.Body)
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("failed to fetch data: %s", resp.Status)
+    }
+    return nil
+}
+
+// getJSON makes an authenticated GET request to the Jira API and decodes the JSON response into v
+func (c *jiraClient) getJSON(apiURL string, v interface{}) error {
+    fmt.Printf("Fetching %s\n", apiURL)
+
+    resp, err := c.get(apiURL)
+    if err != nil {
+        return err
+    }
+    // Log in again when the session has expired
+    if session, ok := c.auth.(expiringAuth); ok && resp.StatusCode == http.StatusUnauthorized {
+        resp.Body.Close()
+        session.expire()
+        resp, err = c.get(apiURL)
+        if err != nil {
+            return err
+        }
+    }
+    defer resp.Body.Close()
+
+    // Check if the response is successful
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("failed to fetch data: %s", resp.Status)
+    }
+
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// get makes an authenticated GET request, retrying failed requests with backoff and
+// holding back further requests while Jira asks to slow down
+func (c *jiraClient) get(apiURL string) (*http.Response, error) {
+    for attempt := 0; ; attempt++ {
+        if waited := c.limiter.wait(); waited > 0 {
+            jiraRateLimitWaits.WithLabelValues(c.instance.name).Inc()
+            jiraRateLimitWaitSeconds.WithLabelValues(c.instance.name).Add(waited.Seconds())
+        }
+
+        // Create a new HTTP request
+        req, err := http.NewRequest("GET", apiURL, nil)
+        if err != nil {
+            return nil, err
+        }
+
+        // Set authentication headers
+        if err := c.auth.authenticate(req); err != nil {
+            return nil, err
+        }
+
+        // Make the HTTP request
+        resp, err := c.http.Do(req)
+        if err == nil {
+            if delay, ok := rateLimitDelay(resp.Header, time.Now()); ok {
+                c.limiter.pause(time.Now().Add(delay))
+            }
+        }
+        if !isRetryable(resp, err) || attempt >= c.cfg.maxRetries {
+            return resp, err
+        }
+
+        delay := retryDelay(resp, attempt, c.cfg.retryBackoff)
+        reason := ""
+        if err != nil {
+            reason = err.Error()
+        } else {
+            reason = resp.Status
+            io.Copy(io.Discard, resp.Body)
+            resp.Body.Close()
+        }
+        fmt.Printf("Request to %s failed (%s), retrying in %s\n", apiURL, reason, delay)
+        jiraHTTPRetries.WithLabelValues(c.instance.name).Inc()
+        c.limiter.pause(time.Now().Add(delay))
+    }
+}
@@ -1,193 +1,293 @@
 package main
 
 import (
-    "encoding/json"
+    "context"
+    "flag"
     "fmt"
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promhttp"
     "net/http"
-    "net/url"
     "os"
-    "slices"
+    "strings"
     "time"
 )
 
 const (
     jiraTimeFormat = "2006-01-02T15:04:05.000-0700"
+    // healthCheckTimeout bounds the active Jira check of /healthz/jira
+    healthCheckTimeout = 5 * time.Second
 )
 
-type config struct {
-    listen            string
-    dataRefreshPeriod time.Duration
-    jiraURL           string
-    jiraUser          string
-    jiraAPIToken      string
-    projects          string
-    analyzePeriodDays string
-}
-
-// fetchJiraData connects to the Jira API and fetches issues data
-func fetchJiraData(cfg config) ([]JiraIssue, error) {
-    issues := make([]JiraIssue, 0)
-    startAt := 0
-    for {
-        issuesChunk, err := fetchStartingFrom(cfg, startAt)
-        if err != nil {
-            return nil, err
-        }
-        if len(issuesChunk) == 0 {
-            break
-        }
-        issues = append(issues, issuesChunk...)
-        startAt += len(issuesChunk)
-    }
-    return issues, nil
-}
-
-func fetchStartingFrom(cfg config, startAt int) ([]JiraIssue, error) {
-    fmt.Printf("Fetching Jira data starting from %d\n", startAt)
-    // Adjust the API URL based on your Jira setup
-    jql := fmt.Sprintf("updated >= -%sd AND project in (%s)", cfg.analyzePeriodDays, cfg.projects)
-    apiURL := fmt.Sprintf("%s/rest/api/3/search?expand=changelog&fields=created,status,assignee,project,issuetype&startAt=%d&jql=%s", cfg.jiraURL, startAt, url.QueryEscape(jql))
-    fmt.Printf("Fetching %s\n", apiURL)
-
-    // Create a new HTTP request
-    req, err := http.NewRequest("GET", apiURL, nil)
-    if err != nil {
-        return nil, err
-    }
-
-    // Set authentication headers
-    req.SetBasicAuth(cfg.jiraUser, cfg.jiraAPIToken)
-
-    // Make the HTTP request
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return nil, err
-    }
-    defer resp.Body.Close()
-
-    // Check if the response is successful
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("failed to fetch data: %s", resp.Status)
-    }
+// Define Prometheus metrics
+var (
+    jiraIssueCount = prometheus.NewDesc(
+        "jira_issue_count",
+        "Count of Jira issues by various labels.",
+        []string{"jira_instance", "query", "project", "priority", "status", "statusCategory", "assignee", "issueType"},
+        nil,
+    )
+    jiraIssueTimeInStatus = prometheus.NewDesc(
+        "jira_issue_time_in_status",
+        "Time spent by issues in each status.",
+        []string{"jira_instance", "query", "project", "priority", "status", "statusCategory", "assignee", "issueType"},
+        nil,
+    )
+    jiraIssueCurrentStatusAge = prometheus.NewDesc(
+        "jira_issue_current_status_age_seconds",
+        "Time spent by issues in their current status since the last transition.",
+        []string{"jira_instance", "query", "project", "priority", "status", "statusCategory", "assignee", "issueType"},
+        nil,
+    )
+    timeInStatusBuckets = prometheus.ExponentialBuckets(1, 10, 8)
 
-    // Decode the JSON response
-    var result struct {
-        Issues []JiraIssue `json:"issues"`
-    }
-    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-        return nil, err
-    }
+    jiraDataAge = prometheus.NewDesc(
+        "jira_exporter_data_age_seconds",
+        "Seconds since the served Jira data was last refreshed successfully.",
+        []string{"jira_instance", "query"},
+        nil,
+    )
 
-    return result.Issues, nil
-}
+    issueMetrics = newIssueCollector(jiraDataAge,
+        jiraIssueCount, jiraIssueTimeInStatus, jiraIssueCurrentStatusAge,
+        jiraIssueLeadTime, jiraIssueCycleTime,
+        jiraIssuesResolved, jiraIssueTransitions,
+        jiraIssueReopensTotal, jiraIssueReopens, jiraIssuesReopened,
+        jiraIssueWIPAgeSinceCreated, jiraIssueWIPAgeSinceStarted, jiraIssueWIPOldestAge,
+        jiraIssueTimeSinceUpdate, jiraIssuesStale,
+        jiraIssueFlowEfficiency,
+    )
 
-// Define Prometheus metrics
-var (
-    jiraIssueCount = prometheus.NewGaugeVec(
+    jiraLastRefreshSuccess = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "jira_exporter_last_refresh_success",
+            Help: "Whether the last refresh of Jira data succeeded (1) or failed (0).",
+        },
+        []string{"jira_instance", "query"},
+    )
+    jiraLastRefreshSuccessTimestamp = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "jira_exporter_last_refresh_success_timestamp_seconds",
+            Help: "Unix time of the last successful refresh of Jira data.",
+        },
+        []string{"jira_instance", "query"},
+    )
+    jiraLastRefreshFailureTimestamp = prometheus.NewGaugeVec(
         prometheus.GaugeOpts{
-            Name: "jira_issue_count",
-            Help: "Count of Jira issues by various labels.",
+            Name: "jira_exporter_last_refresh_failure_timestamp_seconds",
+            Help: "Unix time of the last failed refresh of Jira data.",
         },
-        []string{"project", "priority", "status", "statusCategory", "assignee", "issueType"},
+        []string{"jira_instance", "query"},
     )
-    jiraIssueTimeInStatus = prometheus.NewHistogramVec(
+    jiraRefreshDuration = prometheus.NewHistogramVec(
         prometheus.HistogramOpts{
-            Name:    "jira_issue_time_in_status",
-            Help:    "Time spent by issues in each status.",
-            Buckets: prometheus.ExponentialBuckets(1, 10, 8),
+            Name:    "jira_exporter_refresh_duration_seconds",
+            Help:    "Duration of refreshes of Jira data, successful or not.",
+            Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+        },
+        []string{"jira_instance", "query"},
+    )
+    jiraFetchedIssues = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "jira_exporter_fetched_issues_total",
+            Help: "Number of issues fetched by successful refreshes.",
+        },
+        []string{"jira_instance", "query"},
+    )
+    jiraHTTPRequests = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "jira_exporter_http_requests_total",
+            Help: "Number of requests to Jira by endpoint and status code, \"error\" when no response was received.",
+        },
+        []string{"jira_instance", "endpoint", "code"},
+    )
+    jiraHTTPRetries = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "jira_exporter_http_retries_total",
+            Help: "Number of retried requests to Jira.",
+        },
+        []string{"jira_instance"},
+    )
+    jiraRateLimitWaits = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "jira_exporter_rate_limit_waits_total",
+            Help: "Number of requests to Jira held back by the request budget, a backoff or a rate limit of Jira.",
+        },
+        []string{"jira_instance"},
+    )
+    jiraRateLimitWaitSeconds = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "jira_exporter_rate_limit_wait_seconds_total",
+            Help: "Time requests to Jira were held back by the request budget, a backoff or a rate limit of Jira.",
+        },
+        []string{"jira_instance"},
+    )
+    jiraChangelogFetches = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "jira_exporter_changelog_fetches_total",
+            Help: "Number of follow-up fetches of changelogs truncated in search results.",
+        },
+        []string{"jira_instance"},
+    )
+
+    jiraConfigLastReloadSuccessful = prometheus.NewGauge(
+        prometheus.GaugeOpts{
+            Name: "jira_exporter_config_last_reload_successful",
+            Help: "Whether the last configuration reload attempt was successful (1) or not (0).",
+        },
+    )
+    jiraConfigLastReloadSuccessTimestamp = prometheus.NewGauge(
+        prometheus.GaugeOpts{
+            Name: "jira_exporter_config_last_reload_success_timestamp_seconds",
+            Help: "Unix time of the last successful configuration reload.",
         },
-        []string{"project", "priority", "assignee", "issueType"},
     )
 )
 
 func init() {
     // Register metrics with Prometheus
-    prometheus.MustRegister(jiraIssueCount)
-    prometheus.MustRegister(jiraIssueTimeInStatus)
+    prometheus.MustRegister(issueMetrics)
+    prometheus.MustRegister(jiraLastRefreshSuccess)
+    prometheus.MustRegister(jiraLastRefreshSuccessTimestamp)
+    prometheus.MustRegister(jiraLastRefreshFailureTimestamp)
+    prometheus.MustRegister(jiraRefreshDuration)
+    prometheus.MustRegister(jiraFetchedIssues)
+    prometheus.MustRegister(jiraHTTPRequests)
+    prometheus.MustRegister(jiraHTTPRetries)
+    prometheus.MustRegister(jiraRateLimitWaits)
+    prometheus.MustRegister(jiraRateLimitWaitSeconds)
+    prometheus.MustRegister(jiraChangelogFetches)
+    prometheus.MustRegister(jiraConfigLastReloadSuccessful)
+    prometheus.MustRegister(jiraConfigLastReloadSuccessTimestamp)
+}
+
+// statusCategories resolves the status categories of workflow statuses
+type statusCategories struct {
+    byID   map[string]statusCategory
+    byName map[string]statusCategory
+}
+
+// statusCategory is the category of a workflow status: its key (new, indeterminate or done) and its display name
+type statusCategory struct {
+    key  string
+    name string
+}
+
+func newStatusCategories(statuses []JiraStatus) statusCategories {
+    categories := statusCategories{
+        byID:   make(map[string]statusCategory, len(statuses)),
+        byName: make(map[string]statusCategory, len(statuses)),
+    }
+    for _, status := range statuses {
+        category := statusCategory{key: status.StatusCategory.Key, name: status.StatusCategory.Name}
+        categories.byID[status.ID] = category
+        categories.byName[status.Name] = category
+    }
+    return categories
+}
+
+// lookup returns the category of the status, looking it up by ID first and by name when the ID is unknown
+func (c statusCategories) lookup(id, name string) statusCategory {
+    if category, ok := c.byID[id]; ok {
+        return category
+    }
+    return c.byName[name]
+}
+
+// resolve returns the category name of the status
+func (c statusCategories) resolve(id, name string) string {
+    return c.lookup(id, name).name
 }
 
-// JiraIssue represents the structure of an issue from Jira
-type JiraIssue struct {
-    Key       string `json:"key"`
-    Changelog struct {
-        Histories []struct {
-            Created string `json:"created"`
-            Items   []struct {
-                Field      string      `json:"field"`
-                FromString interface{} `json:"fromString"`
-            } `json:"items"`
-        } `json:"histories"`
-    } `json:"changelog"`
-    Fields struct {
-        Created  string `json:"created"`
-        Priority struct {
-            Name string `json:"name"`
-        } `json:"priority"`
-        Assignee struct {
-            EmailAddress string `json:"emailAddress"`
-        } `json:"assignee"`
-        Status struct {
-            Name           string `json:"name"`
-            StatusCategory struct {
-                Name string `json:"name"`
-            } `json:"statusCategory"`
-        } `json:"status"`
-        IssueType struct {
-            Name string `json:"name"`
-        } `json:"issuetype"`
-        Project struct {
-            Key string `json:"key"`
-        } `json:"project"`
-    } `json:"fields"`
+// issueContext holds what the metrics of the issues of one query are computed with
+type issueContext struct {
+    instance   string
+    query      string
+    categories statusCategories
+    stages     stageMapping
+    activity   statusActivity
+    events     *eventCounter
+    // staleThresholds are the ages over which unfinished issues are counted as stale
+    staleThresholds []time.Duration
+    now             time.Time
 }
 
-// transformDataForPrometheus updates Prometheus metrics instead of returning a string
-func transformDataForPrometheus(issue JiraIssue) {
+// transformDataForPrometheus adds the issue to the metrics snapshot being built
+func transformDataForPrometheus(b *snapshotBuilder, ctx issueContext, issue JiraIssue) {
     //fmt.Printf("Processing issue %s\n", issue.Key)
-    jiraIssueCount.With(prometheus.Labels{
-        "project":        issue.Fields.Project.Key,
-        "priority":       issue.Fields.Priority.Name,
-        "status":         issue.Fields.Status.Name,
-        "statusCategory": issue.Fields.Status.StatusCategory.Name,
-        "assignee":       issue.Fields.Assignee.EmailAddress,
-        "issueType":      issue.Fields.IssueType.Name,
-    }).Inc()
-    calculateStatusDurations(issue)
+    b.addGauge(jiraIssueCount, 1,
+        ctx.instance,
+        ctx.query,
+        issue.Fields.Project.Key,
+        issue.Fields.Priority.Name,
+        issue.Fields.Status.Name,
+        issue.Fields.Status.StatusCategory.Name,
+        issue.Fields.Assignee.EmailAddress,
+        issue.Fields.IssueType.Name,
+    )
+    calculateStatusDurations(b, ctx, issue)
+    calculateFlowTimes(b, ctx, issue)
+    countCompletions(ctx, issue)
+    countTransitions(ctx, issue)
+    calculateReopens(b, ctx, issue)
+    calculateStaleness(b, ctx, issue)
+}
+
+type statusRef struct {
+    id   string
+    name string
 }
 
-func calculateStatusDurations(issue JiraIssue) {
-    statusDurations := make(map[string]time.Duration)
+// calculateStatusDurations observes the time spent in each closed status interval
+// and the age of the still-open interval in the current status
+func calculateStatusDurations(b *snapshotBuilder, ctx issueContext, issue JiraIssue) {
+    statusDurations := make(map[statusRef]time.Duration)
 
-    slices.Reverse(issue.Changelog.Histories)
     statusChangeTime := mustTimeParse(issue.Fields.Created)
     for _, history := range issue.Changelog.Histories {
         changeTime := mustTimeParse(history.Created)
         for _, item := range history.Items {
             if item.Field == "status" {
                 duration := changeTime.Sub(statusChangeTime)
-                statusDurations[item.FromString.(string)] += duration
+                statusDurations[statusRef{id: item.From, name: stringValue(item.FromString)}] += duration
                 statusChangeTime = changeTime
             }
         }
     }
-    for _, duration := range statusDurations {
-        //fmt.Printf("Issue %s spent %s in status %s\n", issue.Key, duration, status)
-        jiraIssueTimeInStatus.With(prometheus.Labels{
-            "project":   issue.Fields.Project.Key,
-            "priority":  issue.Fields.Priority.Name,
-            "assignee":  issue.Fields.Assignee.EmailAddress,
-            "issueType": issue.Fields.IssueType.Name,
-        }).Observe(duration.Seconds())
+    for status, duration := range statusDurations {
+        //fmt.Printf("Issue %s spent %s in status %s\n", issue.Key, duration, status.name)
+        b.observe(jiraIssueTimeInStatus, timeInStatusBuckets, duration.Seconds(),
+            ctx.instance,
+            ctx.query,
+            issue.Fields.Project.Key,
+            issue.Fields.Priority.Name,
+            status.name,
+            ctx.categories.resolve(status.id, status.name),
+            issue.Fields.Assignee.EmailAddress,
+            issue.Fields.IssueType.Name,
+        )
     }
+    b.observe(jiraIssueCurrentStatusAge, timeInStatusBuckets, ctx.now.Sub(statusChangeTime).Seconds(),
+        ctx.instance,
+        ctx.query,
+        issue.Fields.Project.Key,
+        issue.Fields.Priority.Name,
+        issue.Fields.Status.Name,
+        issue.Fields.Status.StatusCategory.Name,
+        issue.Fields.Assignee.EmailAddress,
+        issue.Fields.IssueType.Name,
+    )
 }
 
 // exposeMetrics serves the Prometheus metrics using promhttp
-func exposeMetrics(cfg config) {
+func exposeMetrics(cfg config, s *scheduler, r *reloader) {
     http.Handle("/liveness", livenessHandler())
-    http.Handle("/readiness", readinessHandler(cfg))
+    http.Handle("/readiness", readinessHandler(s))
+    http.Handle("/healthz/jira", jiraHealthHandler(s))
+    if *enableLifecycle {
+        http.Handle("/-/reload", reloadHandler(r))
+    }
+    http.Handle("/api/cfd", cfdHandler())
+    http.Handle("/api/throughput", throughputHandler())
     http.Handle("/metrics", promhttp.Handler())
     fmt.Printf("Serving metrics on %s\n", cfg.listen)
     err := http.ListenAndServe(cfg.listen, nil)
@@ -202,63 +302,76 @@ func livenessHandler() http.Handler {
     })
 }
 
-func readinessHandler(cfg config) http.Handler {
+// readinessHandler reports ready when every configured query serves a snapshot
+// refreshed within cfg.readinessMaxAge. It doesn't call Jira, so probes don't use up
+// the rate limit and a slow Jira doesn't make the exporter flap.
+func readinessHandler(s *scheduler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        _, err := fetchStartingFrom(cfg, 0)
-        if err != nil {
-            fmt.Printf("Error fetching Jira data: %s\n", err)
-            w.WriteHeader(http.StatusInternalServerError)
+        cfg := s.currentConfig()
+        var problems []string
+        for _, instance := range cfg.instances {
+            for _, query := range instance.queries {
+                syncedAt, ok := issueMetrics.syncedAt(instance.name, query.name)
+                if !ok {
+                    problems = append(problems, fmt.Sprintf("instance %s, query %s: no data loaded yet", instance.name, query.name))
+                } else if age := time.Since(syncedAt); age > readinessMaxAge(cfg, query) {
+                    problems = append(problems, fmt.Sprintf("instance %s, query %s: data is %s old", instance.name, query.name, age.Round(time.Second)))
+                }
+            }
+        }
+        if len(problems) > 0 {
+            http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
             return
-        } else {
-            w.WriteHeader(http.StatusOK)
         }
+        w.WriteHeader(http.StatusOK)
     })
 }
 
-func main() {
-    var err error
-    cfg := config{
-        listen:            getEnvOrDie("LISTEN"),
-        analyzePeriodDays: getEnvOrDefault("ANALYZE_PERIOD_DAYS", "90"),
-        jiraURL:           getEnvOrDie("JIRA_URL"),
-        jiraUser:          getEnvOrDie("JIRA_USER"),
-        jiraAPIToken:      getEnvOrDie("JIRA_API_TOKEN"),
-        projects:          getEnvOrDie("PROJECTS"),
-    }
-    cfg.dataRefreshPeriod, err = time.ParseDuration(getEnvOrDefault("DATA_REFRESH_PERIOD", "5m"))
-    failOnError(err)
-    if cfg.analyzePeriodDays == "" {
-        cfg.analyzePeriodDays = "90"
-    }
+// readinessMaxAge returns the maximum age of the metrics of the query for the readiness:
+// cfg.readinessMaxAge, or longer for a query refreshed less often, so it doesn't turn
+// unready before every refresh
+func readinessMaxAge(cfg config, query queryConfig) time.Duration {
+    return max(cfg.readinessMaxAge, query.refreshPeriod+cfg.dataRetryPeriod)
+}
 
-    // Repeat every cfg.dataRefreshPeriod and fetch Jira data
-    go func() {
-        for {
-            jiraIssueCount.Reset()
-            jiraIssueTimeInStatus.Reset()
-            now := time.Now()
-            issues, err := fetchJiraData(cfg)
-            if err != nil {
-                fmt.Println("Error fetching Jira data:", err)
+// jiraHealthHandler checks that every Jira instance is reachable with its credentials,
+// within healthCheckTimeout for all of them
+func jiraHealthHandler(s *scheduler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+        defer cancel()
+        for _, client := range s.jiraClients() {
+            if err := client.checkServerInfo(ctx); err != nil {
+                fmt.Printf("Instance %s: error fetching Jira data: %s\n", client.instance.name, err)
+                http.Error(w, fmt.Sprintf("instance %s: %s", client.instance.name, err), http.StatusServiceUnavailable)
                 return
             }
-            for _, issue := range issues {
-                transformDataForPrometheus(issue)
-            }
-            fmt.Printf("Fetched %d issues in %s\n", len(issues), time.Since(now))
-            time.Sleep(cfg.dataRefreshPeriod)
         }
-    }()
-
-    exposeMetrics(cfg)
+        w.WriteHeader(http.StatusOK)
+    })
 }
 
-func getEnvOrDie(name string) string {
-    value := os.Getenv(name)
-    if value == "" {
-        panic(fmt.Sprintf("%s env is empty", name))
-    }
-    return value
+// configFile is the path of the optional config file
+var (
+    configFile = flag.String("config", "", "path of the JSON config file, settings from envs override it")
+    // enableLifecycle enables the HTTP endpoint that reloads the configuration
+    enableLifecycle = flag.Bool("web.enable-lifecycle", false, "enable the reload of the configuration via HTTP requests to /-/reload")
+)
+
+func main() {
+    flag.Parse()
+    cfg, err := loadConfig(*configFile)
+    failOnError(err)
+
+    // Refresh every query of every instance on its own schedule
+    s := newScheduler()
+    s.apply(cfg)
+    jiraConfigLastReloadSuccessful.Set(1)
+    jiraConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+
+    r := &reloader{configFile: *configFile, scheduler: s}
+    go r.reloadOnSignal()
+    exposeMetrics(cfg, s, r)
 }
 
 func getEnvOrDefault(name string, defaultValue string) string {
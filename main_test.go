@@ -0,0 +1,43 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestReadinessMaxAge(t *testing.T) {
+    cfg := config{readinessMaxAge: time.Hour, dataRetryPeriod: time.Minute}
+    tests := []struct {
+        name          string
+        refreshPeriod time.Duration
+        want          time.Duration
+    }{
+        {"frequent query", 5 * time.Minute, time.Hour},
+        {"hourly query", time.Hour, time.Hour + time.Minute},
+        {"daily query", 24 * time.Hour, 24*time.Hour + time.Minute},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := readinessMaxAge(cfg, queryConfig{refreshPeriod: tt.refreshPeriod}); got != tt.want {
+                t.Errorf("readinessMaxAge() = %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestCalculateStatusDurationsNullStatusName(t *testing.T) {
+    created := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+    issue := testIssue("A-1", created)
+    issue.Fields.Created = created.Format(jiraTimeFormat)
+    issue.Changelog.Histories = []JiraHistory{{
+        ID:      "1",
+        Created: created.Add(time.Hour).Format(jiraTimeFormat),
+        // Jira may send null status names
+        Items: []JiraHistoryItem{{Field: "status", From: "1", FromString: nil, To: "3", ToString: "In Progress"}},
+    }}
+    b := newSnapshotBuilder()
+    calculateStatusDurations(b, issueContext{categories: testStatusCategories(), now: created.Add(2 * time.Hour)}, issue)
+    if len(b.histograms) != 2 {
+        t.Errorf("observed %d histograms, want the time in the unnamed status and the current status age", len(b.histograms))
+    }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+    "text/template"
+    "time"
+    "unicode"
+)
+
+const (
+    // defaultQueryName is the name of the query of an instance without named queries
+    defaultQueryName = "default"
+    // defaultJQL is the JQL template used when no query is configured
+    defaultJQL = "updated >= -{{.AnalyzePeriodDays}}d AND project in ({{.Projects}})"
+)
+
+var (
+    // namePattern is the pattern of instance and query names, which are also used in cache file paths
+    namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+    // orderByPattern matches the start of an ORDER BY clause
+    orderByPattern = regexp.MustCompile(`(?i)^\s*ORDER\s+BY\b`)
+)
+
+// queryConfig is a named JQL query refreshed on its own schedule. Its issues are
+// exported with the query label.
+type queryConfig struct {
+    name              string
+    jql               string
+    refreshPeriod     time.Duration
+    analyzePeriodDays int
+    // windowed is set when the JQL template uses the analysis period, so the query only
+    // matches the issues updated within it and the older ones are dropped between full syncs
+    windowed bool
+}
+
+// querySpec is a named query of an instance: either a JQL template or an object with the query settings
+type querySpec struct {
+    JQL               string `json:"jql"`
+    RefreshPeriod     string `json:"refreshPeriod"`
+    AnalyzePeriodDays int    `json:"analyzePeriodDays"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *querySpec) UnmarshalJSON(data []byte) error {
+    var jql string
+    if err := json.Unmarshal(data, &jql); err == nil {
+        s.JQL = jql
+        return nil
+    }
+    type plain querySpec
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode((*plain)(s)); err != nil {
+        // The offsets of the error are relative to the query, not to the whole document
+        return &fragmentError{fragment: data, offset: decodeErrorOffset(data, dec, err), err: err}
+    }
+    return nil
+}
+
+// jqlTemplateData is the data available to JQL templates
+type jqlTemplateData struct {
+    AnalyzePeriodDays int
+    Projects          string
+}
+
+// newQueries builds the queries of the instance from the named query specs, or the
+// single default query from jqlTemplate when there are none. Settings missing from
+// a spec default to the global ones.
+func newQueries(cfg config, instance instanceConfig, jqlTemplate string, specs map[string]querySpec) ([]queryConfig, error) {
+    if len(specs) == 0 {
+        specs = map[string]querySpec{defaultQueryName: {JQL: jqlTemplate}}
+    }
+    queries := make([]queryConfig, 0, len(specs))
+    for name, spec := range specs {
+        query, err := newQueryConfig(cfg, instance, name, spec)
+        if err != nil {
+            return nil, withPath("queries."+name, err)
+        }
+        queries = append(queries, query)
+    }
+    sort.Slice(queries, func(i, j int) bool { return queries[i].name < queries[j].name })
+    return queries, nil
+}
+
+func newQueryConfig(cfg config, instance instanceConfig, name string, spec querySpec) (queryConfig, error) {
+    query := queryConfig{
+        name:              name,
+        refreshPeriod:     cfg.dataRefreshPeriod,
+        analyzePeriodDays: cfg.analyzePeriodDays,
+    }
+    if !namePattern.MatchString(name) {
+        return query, errors.New("name may only contain letters, digits, '_' and '-'")
+    }
+    if spec.JQL == "" {
+        return query, &configError{path: "jql", err: errors.New("is empty")}
+    }
+    if spec.RefreshPeriod != "" {
+        period, err := time.ParseDuration(spec.RefreshPeriod)
+        if err != nil {
+            return query, &configError{path: "refreshPeriod", err: err}
+        }
+        query.refreshPeriod = period
+    }
+    if spec.AnalyzePeriodDays != 0 {
+        query.analyzePeriodDays = spec.AnalyzePeriodDays
+    }
+    if query.refreshPeriod <= 0 {
+        return query, &configError{path: "refreshPeriod", err: errors.New("must be positive")}
+    }
+    if query.analyzePeriodDays <= 0 {
+        return query, &configError{path: "analyzePeriodDays", err: errors.New("must be positive")}
+    }
+    jql, err := renderJQL(spec.JQL, query.analyzePeriodDays, instance.projects)
+    if err != nil {
+        return query, &configError{path: "jql", err: err}
+    }
+    query.jql = jql
+    query.windowed = strings.Contains(spec.JQL, ".AnalyzePeriodDays")
+    return query, nil
+}
+
+// renderJQL fills the JQL template with the analysis period and the projects
+func renderJQL(tmpl string, analyzePeriodDays int, projects string) (string, error) {
+    if strings.Contains(tmpl, ".Projects") && projects == "" {
+        return "", fmt.Errorf("JQL uses .Projects but the projects are empty")
+    }
+    t, err := template.New("jql").Option("missingkey=error").Parse(tmpl)
+    if err != nil {
+        return "", err
+    }
+    var jql strings.Builder
+    err = t.Execute(&jql, jqlTemplateData{AnalyzePeriodDays: analyzePeriodDays, Projects: projects})
+    return strings.TrimSpace(jql.String()), err
+}
+
+// andJQL narrows the JQL query with the clause, keeping its ORDER BY at the end
+func andJQL(jql string, clause string) string {
+    where, orderBy := splitOrderBy(jql)
+    if strings.TrimSpace(where) == "" {
+        return clause + orderBy
+    }
+    return fmt.Sprintf("(%s) AND %s%s", where, clause, orderBy)
+}
+
+// splitOrderBy splits the JQL query at its ORDER BY clause, skipping the quoted strings
+func splitOrderBy(jql string) (string, string) {
+    var quote rune
+    escaped := false
+    for i, r := range jql {
+        switch {
+        case escaped:
+            escaped = false
+        case quote != 0 && r == '\\':
+            escaped = true
+        case quote != 0:
+            if r == quote {
+                quote = 0
+            }
+        case r == '"' || r == '\'':
+            quote = r
+        case (i == 0 || unicode.IsSpace(r)) && orderByPattern.MatchString(jql[i:]):
+            return jql[:i], " " + strings.TrimSpace(jql[i:])
+        }
+    }
+    return jql, ""
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestAndJQL(t *testing.T) {
+    tests := []struct {
+        name string
+        jql  string
+        want string
+    }{
+        {"no order", "project = A", "(project = A) AND updated >= -5m"},
+        {"order", "project = A ORDER BY created DESC", "(project = A) AND updated >= -5m ORDER BY created DESC"},
+        {"lowercase order", "project = A\norder  by created", "(project = A) AND updated >= -5m order  by created"},
+        {"only order", "ORDER BY created", "updated >= -5m ORDER BY created"},
+        {"quoted order", `summary ~ "sort order by date"`, `(summary ~ "sort order by date") AND updated >= -5m`},
+        {"single quoted order", `summary ~ 'order by' ORDER BY key`, `(summary ~ 'order by') AND updated >= -5m ORDER BY key`},
+        {"escaped quote", `summary ~ "say \" order by" ORDER BY key`, `(summary ~ "say \" order by") AND updated >= -5m ORDER BY key`},
+        {"word containing order", "labels = reorder", "(labels = reorder) AND updated >= -5m"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := andJQL(tt.jql, "updated >= -5m"); got != tt.want {
+                t.Errorf("andJQL(%q) = %q, want %q", tt.jql, got, tt.want)
+            }
+        })
+    }
+}
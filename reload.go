@@ -0,0 +1,67 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+)
+
+// reloader reloads the config file and applies it to the scheduler
+type reloader struct {
+    // mu serializes reloads from signals and HTTP requests
+    mu         sync.Mutex
+    configFile string
+    scheduler  *scheduler
+}
+
+// reload loads and validates the config and applies it only when it is valid, so an
+// invalid config leaves the running jobs in place
+func (r *reloader) reload() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    cfg, err := loadConfig(r.configFile)
+    if err != nil {
+        jiraConfigLastReloadSuccessful.Set(0)
+        return err
+    }
+    if listen := r.scheduler.currentConfig().listen; cfg.listen != listen {
+        fmt.Printf("Still listening on %s, a new listen address needs a restart\n", listen)
+        cfg.listen = listen
+    }
+    r.scheduler.apply(cfg)
+    jiraConfigLastReloadSuccessful.Set(1)
+    jiraConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+    fmt.Println("Configuration reloaded")
+    return nil
+}
+
+// reloadOnSignal reloads the config on every SIGHUP
+func (r *reloader) reloadOnSignal() {
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGHUP)
+    for range signals {
+        if err := r.reload(); err != nil {
+            fmt.Printf("Error reloading configuration: %s\n", err)
+        }
+    }
+}
+
+// reloadHandler reloads the config on POST requests
+func reloadHandler(r *reloader) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        if req.Method != http.MethodPost {
+            w.Header().Set("Allow", http.MethodPost)
+            w.WriteHeader(http.StatusMethodNotAllowed)
+            return
+        }
+        if err := r.reload(); err != nil {
+            fmt.Printf("Error reloading configuration: %s\n", err)
+            http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusOK)
+    })
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "math/rand"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// maxRetryBackoff caps the exponential backoff between retries
+const maxRetryBackoff = time.Minute
+
+// rateLimiter spaces requests to stay within the request rate budget and holds
+// them back while Jira asks to slow down
+type rateLimiter struct {
+    interval time.Duration
+
+    mu   sync.Mutex
+    next time.Time
+}
+
+// newRateLimiter returns a limiter allowing requestsPerSecond requests, or any number of them when it is not positive
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+    l := &rateLimiter{}
+    if requestsPerSecond > 0 {
+        l.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+    }
+    return l
+}
+
+// wait blocks until the next request is allowed and returns how long it waited
+func (l *rateLimiter) wait() time.Duration {
+    l.mu.Lock()
+    now := time.Now()
+    at := now
+    if l.next.After(at) {
+        at = l.next
+    }
+    l.next = at.Add(l.interval)
+    l.mu.Unlock()
+
+    delay := at.Sub(now)
+    if delay > 0 {
+        time.Sleep(delay)
+    }
+    return delay
+}
+
+// pause holds back all requests until the given time
+func (l *rateLimiter) pause(until time.Time) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if until.After(l.next) {
+        l.next = until
+    }
+}
+
+// isRetryable reports whether the request failed in a way that may succeed when it is repeated
+func isRetryable(resp *http.Response, err error) bool {
+    if err != nil {
+        return true
+    }
+    switch resp.StatusCode {
+    case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+        http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+        return true
+    }
+    return false
+}
+
+// retryDelay returns how long to wait before the next attempt: the delay Jira asked for
+// or an exponential backoff with full jitter, none when the backoff is 0
+func retryDelay(resp *http.Response, attempt int, backoff time.Duration) time.Duration {
+    if resp != nil {
+        if delay, ok := rateLimitDelay(resp.Header, time.Now()); ok && delay > 0 {
+            return delay
+        }
+    }
+    if backoff <= 0 {
+        return 0
+    }
+    ceiling := backoff << attempt
+    if ceiling <= 0 || ceiling > maxRetryBackoff {
+        ceiling = maxRetryBackoff
+    }
+    return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// rateLimitDelay returns the delay requested by the Retry-After header or, when the
+// request budget is exhausted, the time until the X-RateLimit-Reset
+func rateLimitDelay(header http.Header, now time.Time) (time.Duration, bool) {
+    if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+        if seconds, err := strconv.Atoi(retryAfter); err == nil {
+            return time.Duration(seconds) * time.Second, true
+        }
+        if at, err := http.ParseTime(retryAfter); err == nil {
+            return at.Sub(now), true
+        }
+    }
+    if header.Get("X-RateLimit-Remaining") == "0" {
+        if at, ok := parseRateLimitReset(header.Get("X-RateLimit-Reset")); ok {
+            return at.Sub(now), true
+        }
+    }
+    return 0, false
+}
+
+// parseRateLimitReset parses the X-RateLimit-Reset header, an ISO 8601 timestamp on Jira Cloud
+func parseRateLimitReset(value string) (time.Time, bool) {
+    for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00"} {
+        if at, err := time.Parse(layout, value); err == nil {
+            return at, true
+        }
+    }
+    return time.Time{}, false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestRateLimitDelay(t *testing.T) {
+    now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name    string
+        header  map[string]string
+        want    time.Duration
+        wantSet bool
+    }{
+        {"no headers", nil, 0, false},
+        {"retry after seconds", map[string]string{"Retry-After": "30"}, 30 * time.Second, true},
+        {"retry after date", map[string]string{"Retry-After": now.Add(2 * time.Minute).Format(http.TimeFormat)}, 2 * time.Minute, true},
+        {"invalid retry after", map[string]string{"Retry-After": "soon"}, 0, false},
+        {"exhausted budget", map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "2024-05-10T12:05:00Z"}, 5 * time.Minute, true},
+        {"exhausted budget with reset in minutes", map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "2024-05-10T12:03Z"}, 3 * time.Minute, true},
+        {"remaining budget", map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "2024-05-10T12:05:00Z"}, 0, false},
+        {"exhausted budget without reset", map[string]string{"X-RateLimit-Remaining": "0"}, 0, false},
+        {"retry after over reset", map[string]string{"Retry-After": "7", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "2024-05-10T12:05:00Z"}, 7 * time.Second, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            header := make(http.Header)
+            for name, value := range tt.header {
+                header.Set(name, value)
+            }
+            got, ok := rateLimitDelay(header, now)
+            if got != tt.want || ok != tt.wantSet {
+                t.Errorf("rateLimitDelay() = %s, %t, want %s, %t", got, ok, tt.want, tt.wantSet)
+            }
+        })
+    }
+}
+
+func TestParseRateLimitReset(t *testing.T) {
+    tests := []struct {
+        value  string
+        want   time.Time
+        wantOK bool
+    }{
+        {"2024-05-10T12:05:00Z", time.Date(2024, 5, 10, 12, 5, 0, 0, time.UTC), true},
+        {"2024-05-10T14:05:30+02:00", time.Date(2024, 5, 10, 12, 5, 30, 0, time.UTC), true},
+        {"2024-05-10T12:05Z", time.Date(2024, 5, 10, 12, 5, 0, 0, time.UTC), true},
+        {"1715342700", time.Time{}, false},
+        {"", time.Time{}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.value, func(t *testing.T) {
+            got, ok := parseRateLimitReset(tt.value)
+            if !got.Equal(tt.want) || ok != tt.wantOK {
+                t.Errorf("parseRateLimitReset(%q) = %s, %t, want %s, %t", tt.value, got, ok, tt.want, tt.wantOK)
+            }
+        })
+    }
+}
+
+func TestRetryDelay(t *testing.T) {
+    tests := []struct {
+        name    string
+        header  http.Header
+        attempt int
+        backoff time.Duration
+        min     time.Duration
+        max     time.Duration
+    }{
+        {"first attempt", nil, 0, time.Second, 1, time.Second},
+        {"third attempt", nil, 2, time.Second, 1, 4 * time.Second},
+        {"capped", nil, 10, time.Second, 1, maxRetryBackoff},
+        {"overflowing shift", nil, 70, time.Second, 1, maxRetryBackoff},
+        {"no backoff", nil, 3, 0, 0, 0},
+        {"requested delay", http.Header{"Retry-After": {"90"}}, 0, time.Second, 90 * time.Second, 90 * time.Second},
+        {"requested delay without backoff", http.Header{"Retry-After": {"5"}}, 0, 0, 5 * time.Second, 5 * time.Second},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var resp *http.Response
+            if tt.header != nil {
+                resp = &http.Response{Header: tt.header}
+            }
+            // The backoff is jittered, so check the bounds of many delays
+            for i := 0; i < 100; i++ {
+                if got := retryDelay(resp, tt.attempt, tt.backoff); got < tt.min || got > tt.max {
+                    t.Fatalf("retryDelay() = %s, want between %s and %s", got, tt.min, tt.max)
+                }
+            }
+        })
+    }
+}
+
+func TestRateLimiter(t *testing.T) {
+    tests := []struct {
+        name              string
+        requestsPerSecond float64
+        pause             time.Duration
+        // min is the least total wait of three requests
+        min time.Duration
+    }{
+        {"unlimited", 0, 0, 0},
+        {"spaced", 50, 0, 20 * time.Millisecond},
+        {"paused", 0, 30 * time.Millisecond, 20 * time.Millisecond},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            l := newRateLimiter(tt.requestsPerSecond)
+            if tt.pause > 0 {
+                l.pause(time.Now().Add(tt.pause))
+            }
+            var waited time.Duration
+            for i := 0; i < 3; i++ {
+                waited += l.wait()
+            }
+            if waited < tt.min {
+                t.Errorf("waited %s, want at least %s", waited, tt.min)
+            }
+            if tt.min == 0 && waited != 0 {
+                t.Errorf("waited %s, want no wait", waited)
+            }
+        })
+    }
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "reflect"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// scheduler runs the jobs of the current config and replaces them when the config is reloaded
+type scheduler struct {
+    mu      sync.Mutex
+    cfg     config
+    clients []*jiraClient
+    // jobs are the jobs of the current config and the removed jobs that are still stopping, by instance/query
+    jobs map[string]*job
+}
+
+func newScheduler() *scheduler {
+    return &scheduler{jobs: make(map[string]*job)}
+}
+
+// apply starts the jobs of the config and stops the jobs that are no longer part of it.
+// The jobs whose query, instance and settings didn't change keep running untouched. The
+// snapshots of the restarted queries stay served until their new jobs publish, and a
+// restarted query of an unchanged instance with the same JQL keeps its issue store, so
+// it goes on with incremental syncs.
+func (s *scheduler) apply(cfg config) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    jobs := make(map[string]*job)
+    clients := make([]*jiraClient, 0, len(cfg.instances))
+    for _, instance := range cfg.instances {
+        old := s.client(instance.name)
+        // The issues fetched from the same Jira with the same credentials stay valid
+        sameJira := old != nil && sameInstance(old.instance, instance)
+        unchanged := sameJira && sameJobSettings(s.cfg, cfg)
+        client := old
+        if !unchanged {
+            client = newJiraClient(cfg, instance)
+        }
+        clients = append(clients, client)
+        for _, query := range instance.queries {
+            key := instance.name + "/" + query.name
+            previous := s.jobs[key]
+            // Removed jobs that are still stopping are marked removed
+            if unchanged && previous != nil && !previous.removed.Load() && previous.query == query {
+                jobs[key] = previous
+                continue
+            }
+            if previous != nil {
+                previous.cancel()
+                previous.removed.Store(false)
+            }
+            ctx, cancel := context.WithCancel(context.Background())
+            j := &job{client: client, query: query, cancel: cancel, done: make(chan struct{})}
+            jobs[key] = j
+            go j.start(ctx, cfg, previous, sameJira)
+        }
+    }
+    for key, old := range s.jobs {
+        if _, ok := jobs[key]; ok {
+            continue
+        }
+        old.cancel()
+        old.removed.Store(true)
+        select {
+        case <-old.done:
+        default:
+            jobs[key] = old
+        }
+    }
+    s.cfg = cfg
+    s.clients = clients
+    s.jobs = jobs
+}
+
+// client returns the client of the instance of the current config, or nil when there is none
+func (s *scheduler) client(instance string) *jiraClient {
+    for _, client := range s.clients {
+        if client.instance.name == instance {
+            return client
+        }
+    }
+    return nil
+}
+
+// sameInstance reports whether the instances are configured the same, apart from their queries
+func sameInstance(a, b instanceConfig) bool {
+    a.queries, b.queries = nil, nil
+    return reflect.DeepEqual(a, b)
+}
+
+// sameJobSettings reports whether the configs have the same settings of the clients and
+// the jobs, which are all of them except the instances, the listen address and the readiness
+func sameJobSettings(a, b config) bool {
+    a.instances, b.instances = nil, nil
+    a.listen, b.listen = "", ""
+    a.readinessMaxAge, b.readinessMaxAge = 0, 0
+    return reflect.DeepEqual(a, b)
+}
+
+// currentConfig returns the config of the running jobs
+func (s *scheduler) currentConfig() config {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.cfg
+}
+
+// jiraClients returns the clients of the instances of the current config
+func (s *scheduler) jiraClients() []*jiraClient {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.clients
+}
+
+// job refreshes the issues of one query on its own schedule
+type job struct {
+    client *jiraClient
+    query  queryConfig
+    store  *issueStore
+    events *eventCounter
+    cancel context.CancelFunc
+    // removed is set when the query is no longer configured, so its metrics are dropped once the job stops
+    removed atomic.Bool
+    // done is closed when the job has stopped
+    done chan struct{}
+}
+
+// start waits for the previous job of the query, if any, to stop, takes over its store
+// when it fetched from the same Jira with the same JQL and runs the job until ctx is cancelled
+func (j *job) start(ctx context.Context, cfg config, previous *job, sameJira bool) {
+    defer close(j.done)
+    if previous != nil {
+        <-previous.done
+        if sameJira && previous.store.query.jql == j.query.jql {
+            j.store = previous.store
+            j.store.query = j.query
+            j.events = previous.events
+        }
+    }
+    if j.store == nil {
+        j.store = newIssueStore(j.query)
+        j.loadCache(cfg)
+        // Count the events since the cached sync too, they happened while the exporter was down
+        start := j.store.syncedAt
+        if start.IsZero() {
+            start = time.Now()
+        }
+        j.events = newEventCounter(start)
+        if !j.store.syncedAt.IsZero() {
+            j.publish(cfg, time.Now())
+        }
+    }
+    j.run(ctx, cfg)
+    if j.removed.Load() {
+        issueMetrics.remove(j.client.instance.name, j.query.name)
+        jiraLastRefreshSuccess.DeleteLabelValues(j.client.instance.name, j.query.name)
+        jiraLastRefreshSuccessTimestamp.DeleteLabelValues(j.client.instance.name, j.query.name)
+        jiraLastRefreshFailureTimestamp.DeleteLabelValues(j.client.instance.name, j.query.name)
+        jiraRefreshDuration.DeleteLabelValues(j.client.instance.name, j.query.name)
+        jiraFetchedIssues.DeleteLabelValues(j.client.instance.name, j.query.name)
+        fmt.Printf("Instance %s, query %s: removed\n", j.client.instance.name, j.query.name)
+    }
+}
+
+// loadCache restores the cached issues of the query, if any, so they are served
+// until the first refresh catches up
+func (j *job) loadCache(cfg config) {
+    if cfg.cacheDir == "" {
+        return
+    }
+    if err := j.store.load(cacheFile(cfg, j.client.instance.name, j.query.name), j.client.instance); err != nil {
+        fmt.Printf("Instance %s, query %s: error loading issue cache: %s\n", j.client.instance.name, j.query.name, err)
+    } else if !j.store.syncedAt.IsZero() {
+        fmt.Printf("Instance %s, query %s: loaded %d issues synced at %s from the cache\n", j.client.instance.name, j.query.name, len(j.store.issues), j.store.syncedAt)
+    }
+}
+
+// run refreshes the job every refresh period of the query, retrying failed refreshes
+// after cfg.dataRetryPeriod, until ctx is cancelled. The first refresh waits until the
+// stored issues are due, so restarting a job doesn't make it sync early.
+func (j *job) run(ctx context.Context, cfg config) {
+    if !j.store.syncedAt.IsZero() {
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(time.Until(j.store.syncedAt.Add(j.query.refreshPeriod))):
+        }
+    }
+    for ctx.Err() == nil {
+        wait := j.query.refreshPeriod
+        if err := j.refresh(cfg); err != nil {
+            fmt.Printf("Instance %s, query %s: error fetching Jira data: %s\n", j.client.instance.name, j.query.name, err)
+            jiraLastRefreshSuccess.WithLabelValues(j.client.instance.name, j.query.name).Set(0)
+            wait = cfg.dataRetryPeriod
+        }
+        select {
+        case <-ctx.Done():
+        case <-time.After(wait):
+        }
+    }
+}
+
+// refresh fetches Jira data and publishes a new metrics snapshot only when the fetch
+// succeeded, so a failed cycle keeps the previous values in place
+func (j *job) refresh(cfg config) error {
+    now := time.Now()
+    fetched, err := j.store.sync(j.client, cfg, now)
+    jiraRefreshDuration.WithLabelValues(j.client.instance.name, j.query.name).Observe(time.Since(now).Seconds())
+    if err != nil {
+        jiraLastRefreshFailureTimestamp.WithLabelValues(j.client.instance.name, j.query.name).Set(float64(now.Unix()))
+        return err
+    }
+    jiraFetchedIssues.WithLabelValues(j.client.instance.name, j.query.name).Add(float64(fetched))
+    if cfg.cacheDir != "" {
+        if err := j.store.save(cacheFile(cfg, j.client.instance.name, j.query.name), j.client.instance); err != nil {
+            // The cache only speeds up restarts, the fetched data is still good
+            fmt.Printf("Instance %s, query %s: error saving issue cache: %s\n", j.client.instance.name, j.query.name, err)
+        }
+    }
+    j.publish(cfg, now)
+    fmt.Printf("Instance %s, query %s: fetched %d issues in %s, %d issues in the analysis window\n", j.client.instance.name, j.query.name, fetched, time.Since(now), len(j.store.issues))
+    return nil
+}
+
+// publish builds the metrics from the stored issues and swaps them in
+func (j *job) publish(cfg config, now time.Time) {
+    ctx := issueContext{
+        instance:        j.client.instance.name,
+        query:           j.query.name,
+        categories:      newStatusCategories(j.store.statuses),
+        stages:          j.client.instance.stages,
+        activity:        j.client.instance.activity,
+        events:          j.events,
+        staleThresholds: cfg.staleThresholds,
+        now:             now,
+    }
+    j.events.prune(now.AddDate(0, 0, -j.query.analyzePeriodDays))
+    b := newSnapshotBuilder()
+    for _, issue := range j.store.issues {
+        transformDataForPrometheus(b, ctx, issue)
+    }
+    j.events.addTo(b)
+    issueMetrics.publish(ctx.instance, ctx.query, b,
+        cumulativeFlow(ctx, j.store.issues, j.query.analyzePeriodDays),
+        dailyThroughput(ctx, j.store.issues, j.query.analyzePeriodDays),
+        j.store.syncedAt,
+    )
+    jiraLastRefreshSuccess.WithLabelValues(j.client.instance.name, j.query.name).Set(1)
+    jiraLastRefreshSuccessTimestamp.WithLabelValues(j.client.instance.name, j.query.name).Set(float64(j.store.syncedAt.Unix()))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+    "sync"
+    "testing"
+    "time"
+)
+
+func testQuery(name string, jql string) queryConfig {
+    return queryConfig{name: name, jql: jql, refreshPeriod: time.Hour, analyzePeriodDays: 90}
+}
+
+// testSchedulerConfig returns a config of the instance with the queries, which refresh once and then wait
+func testSchedulerConfig(instance instanceConfig, queries ...queryConfig) config {
+    instance.queries = queries
+    return config{
+        pageSize:             100,
+        changelogConcurrency: 1,
+        requestTimeout:       time.Second,
+        dataRetryPeriod:      time.Hour,
+        fullSyncPeriod:       time.Hour,
+        syncOverlap:          5 * time.Minute,
+        instances:            []instanceConfig{instance},
+    }
+}
+
+// waitPublished waits until the snapshot of the query of the instance is served
+func waitPublished(t *testing.T, instance string, query string) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        if _, ok := issueMetrics.syncedAt(instance, query); ok {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("instance %s, query %s: no snapshot published", instance, query)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+// waitStopped waits until the job has stopped
+func waitStopped(t *testing.T, j *job) {
+    t.Helper()
+    select {
+    case <-j.done:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("instance %s, query %s: job didn't stop", j.client.instance.name, j.query.name)
+    }
+}
+
+// stopJobs stops the jobs of the scheduler and waits until they have stopped
+func stopJobs(t *testing.T, s *scheduler) {
+    t.Helper()
+    jobs := s.jobs
+    s.apply(config{})
+    for _, j := range jobs {
+        waitStopped(t, j)
+    }
+}
+
+func TestSchedulerApplyUnchanged(t *testing.T) {
+    _, client := newFakeJira(t)
+    instance := client.instance
+    instance.name = "unchanged"
+    s := newScheduler()
+    s.apply(testSchedulerConfig(instance, testQuery("a", "project = A")))
+    t.Cleanup(func() { stopJobs(t, s) })
+    waitPublished(t, "unchanged", "a")
+    running := s.jobs["unchanged/a"]
+
+    s.apply(testSchedulerConfig(instance, testQuery("a", "project = A")))
+    if s.jobs["unchanged/a"] != running {
+        t.Error("the unchanged query was restarted")
+    }
+    select {
+    case <-running.done:
+        t.Error("the unchanged query was stopped")
+    default:
+    }
+}
+
+func TestSchedulerApplyRestart(t *testing.T) {
+    tests := []struct {
+        name      string
+        instance  string
+        query     queryConfig
+        wantStore bool
+    }{
+        {
+            name:      "same JQL keeps the store",
+            instance:  "restart-same",
+            query:     queryConfig{name: "a", jql: "project = A", refreshPeriod: 2 * time.Hour, analyzePeriodDays: 90},
+            wantStore: true,
+        },
+        {
+            name:      "new JQL starts over",
+            instance:  "restart-new",
+            query:     testQuery("a", "project = B"),
+            wantStore: false,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, client := newFakeJira(t)
+            instance := client.instance
+            instance.name = tt.instance
+            s := newScheduler()
+            s.apply(testSchedulerConfig(instance, testQuery("a", "project = A")))
+            waitPublished(t, instance.name, "a")
+            previous := s.jobs[instance.name+"/a"]
+            store := previous.store
+
+            s.apply(testSchedulerConfig(instance, tt.query))
+            restarted := s.jobs[instance.name+"/a"]
+            if restarted == previous {
+                t.Fatal("the changed query wasn't restarted")
+            }
+            waitStopped(t, previous)
+            stopJobs(t, s)
+            if got := restarted.store == store; got != tt.wantStore {
+                t.Errorf("kept the store = %v, want %v", got, tt.wantStore)
+            }
+            if restarted.store.query != tt.query {
+                t.Errorf("store query = %+v, want %+v", restarted.store.query, tt.query)
+            }
+        })
+    }
+}
+
+func TestSchedulerApplyRemoved(t *testing.T) {
+    _, client := newFakeJira(t)
+    instance := client.instance
+    instance.name = "removed"
+    s := newScheduler()
+    s.apply(testSchedulerConfig(instance, testQuery("a", "project = A"), testQuery("b", "project = B")))
+    t.Cleanup(func() { stopJobs(t, s) })
+    waitPublished(t, "removed", "a")
+    waitPublished(t, "removed", "b")
+    removed := s.jobs["removed/a"]
+
+    s.apply(testSchedulerConfig(instance, testQuery("b", "project = B")))
+    waitStopped(t, removed)
+    if _, ok := issueMetrics.syncedAt("removed", "a"); ok {
+        t.Error("the metrics of the removed query are still served")
+    }
+    if _, ok := issueMetrics.syncedAt("removed", "b"); !ok {
+        t.Error("the metrics of the kept query were dropped")
+    }
+}
+
+func TestSchedulerApplyReAddedWhileStopping(t *testing.T) {
+    jira, client := newFakeJira(t)
+    // The first refreshes hang, so the removed job is still stopping when the query is added back
+    jira.block = make(chan struct{})
+    release := sync.OnceFunc(func() { close(jira.block) })
+    instance := client.instance
+    instance.name = "readded"
+    s := newScheduler()
+    s.apply(testSchedulerConfig(instance, testQuery("a", "project = A"), testQuery("b", "project = B")))
+    t.Cleanup(func() {
+        release()
+        stopJobs(t, s)
+    })
+    stopping := s.jobs["readded/a"]
+    deadline := time.Now().Add(5 * time.Second)
+    for jira.searchCount() < 2 {
+        if time.Now().After(deadline) {
+            t.Fatal("the jobs didn't start their refreshes")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    s.apply(testSchedulerConfig(instance, testQuery("b", "project = B")))
+    if s.jobs["readded/a"] != stopping {
+        t.Fatal("the removed job was forgotten before it stopped")
+    }
+    s.apply(testSchedulerConfig(instance, testQuery("a", "project = A"), testQuery("b", "project = B")))
+    readded := s.jobs["readded/a"]
+    if readded == stopping {
+        t.Fatal("the stopping job was kept")
+    }
+
+    release()
+    waitStopped(t, stopping)
+    // The old job published its refresh and must leave it to the new job, which takes over the store
+    if _, ok := issueMetrics.syncedAt("readded", "a"); !ok {
+        t.Error("the stopping job dropped the metrics of the added back query")
+    }
+    stopJobs(t, s)
+    if readded.store != stopping.store {
+        t.Error("the added back query didn't take over the store of the stopping job")
+    }
+}
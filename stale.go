@@ -0,0 +1,70 @@
+package main
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    jiraIssueTimeSinceUpdate = prometheus.NewDesc(
+        "jira_issue_time_since_update_seconds",
+        "Time since the last update of issues that are not done.",
+        []string{"jira_instance", "query", "project", "status", "issueType"},
+        nil,
+    )
+    jiraIssuesStale = prometheus.NewDesc(
+        "jira_issues_stale",
+        "Number of issues that are not done and were not updated or transitioned for longer than the threshold.",
+        []string{"jira_instance", "query", "project", "status", "issueType", "since", "threshold"},
+        nil,
+    )
+)
+
+// calculateStaleness observes the time since the last update of the unfinished issue
+// and counts it as stale over every threshold its last update and its last transition
+// are older than. The time since the last transition is jira_issue_current_status_age_seconds.
+func calculateStaleness(b *snapshotBuilder, ctx issueContext, issue JiraIssue) {
+    if issue.Fields.Status.StatusCategory.Key == "done" {
+        return
+    }
+    labelValues := []string{
+        ctx.instance,
+        ctx.query,
+        issue.Fields.Project.Key,
+        issue.Fields.Status.Name,
+        issue.Fields.IssueType.Name,
+    }
+    sinceUpdate := ctx.now.Sub(mustTimeParse(issue.Fields.Updated))
+    b.observe(jiraIssueTimeSinceUpdate, timeInStatusBuckets, sinceUpdate.Seconds(), labelValues...)
+
+    lastTransition := mustTimeParse(issue.Fields.Created)
+    if transitions := statusTransitions(issue); len(transitions) > 0 {
+        lastTransition = transitions[len(transitions)-1].at
+    }
+    sinceTransition := ctx.now.Sub(lastTransition)
+    for _, threshold := range ctx.staleThresholds {
+        // Report every threshold, so the counts go down to 0 instead of disappearing
+        for since, age := range map[string]time.Duration{"update": sinceUpdate, "transition": sinceTransition} {
+            stale := 0.0
+            if age > threshold {
+                stale = 1
+            }
+            b.addGauge(jiraIssuesStale, stale, append(labelValues, since, formatThreshold(threshold))...)
+        }
+    }
+}
+
+// formatThreshold formats the threshold in the largest whole unit of days, hours or minutes
+func formatThreshold(threshold time.Duration) string {
+    switch {
+    case threshold%(24*time.Hour) == 0:
+        return fmt.Sprintf("%dd", threshold/(24*time.Hour))
+    case threshold%time.Hour == 0:
+        return fmt.Sprintf("%dh", threshold/time.Hour)
+    case threshold%time.Minute == 0:
+        return fmt.Sprintf("%dm", threshold/time.Minute)
+    }
+    return threshold.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+)
+
+// issueStore keeps the issues of the analysis window between refreshes, so that
+// after the first full load only the recently updated issues are fetched
+type issueStore struct {
+    query    queryConfig
+    issues   map[string]JiraIssue
+    statuses []JiraStatus
+    // syncedAt is the watermark: the start of the last successful sync
+    syncedAt time.Time
+    // fullSyncedAt is the start of the last successful full sync
+    fullSyncedAt time.Time
+}
+
+func newIssueStore(query queryConfig) *issueStore {
+    return &issueStore{query: query, issues: make(map[string]JiraIssue)}
+}
+
+// sync fetches the issues updated since the watermark, or all issues of the analysis
+// window when a full reconciliation is due, and returns the number of fetched issues
+func (s *issueStore) sync(client *jiraClient, cfg config, now time.Time) (int, error) {
+    jql := s.query.jql
+    full := s.fullSyncedAt.IsZero() || now.Sub(s.fullSyncedAt) >= cfg.fullSyncPeriod
+    if !full {
+        // A relative date does not depend on the time zone of the Jira user
+        minutes := int(math.Ceil((now.Sub(s.syncedAt) + cfg.syncOverlap).Minutes()))
+        jql = andJQL(jql, fmt.Sprintf("updated >= -%dm", minutes))
+    }
+
+    fetched, err := client.fetchIssues(jql)
+    if err != nil {
+        return 0, err
+    }
+    statuses, err := client.fetchStatuses()
+    if err != nil {
+        return 0, err
+    }
+    s.statuses = statuses
+
+    if full {
+        // Drop the issues that no longer match the query
+        s.issues = make(map[string]JiraIssue, len(fetched))
+        s.fullSyncedAt = now
+    }
+    for _, issue := range fetched {
+        s.issues[issue.Key] = issue
+    }
+    s.syncedAt = now
+    if s.query.windowed {
+        s.evict(now.AddDate(0, 0, -s.query.analyzePeriodDays))
+    }
+    return len(fetched), nil
+}
+
+// evict removes the issues last updated before the start of the analysis window, which
+// no longer match a query narrowed to the window but are only dropped by the next full sync
+func (s *issueStore) evict(windowStart time.Time) {
+    for key, issue := range s.issues {
+        if mustTimeParse(issue.Fields.Updated).Before(windowStart) {
+            delete(s.issues, key)
+        }
+    }
+}
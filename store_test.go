@@ -0,0 +1,163 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "slices"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+)
+
+// fakeJira serves the issues from the legacy search API and records the searched JQL
+type fakeJira struct {
+    mu     sync.Mutex
+    issues []JiraIssue
+    jql    string
+    // searches is the number of received searches
+    searches int
+    // block holds the searches until it is closed, when set
+    block chan struct{}
+}
+
+// searchCount returns the number of received searches
+func (jira *fakeJira) searchCount() int {
+    jira.mu.Lock()
+    defer jira.mu.Unlock()
+    return jira.searches
+}
+
+func newFakeJira(t *testing.T) (*fakeJira, *jiraClient) {
+    t.Helper()
+    jira := &fakeJira{}
+    mux := http.NewServeMux()
+    mux.HandleFunc("/rest/api/2/search", func(w http.ResponseWriter, r *http.Request) {
+        jira.mu.Lock()
+        jira.jql = r.URL.Query().Get("jql")
+        jira.searches++
+        issues, total := jira.issues, len(jira.issues)
+        jira.mu.Unlock()
+        if r.URL.Query().Get("startAt") != "0" {
+            issues = nil
+        }
+        if jira.block != nil {
+            <-jira.block
+        }
+        _ = json.NewEncoder(w).Encode(searchLegacyPage{Issues: issues, Total: total})
+    })
+    mux.HandleFunc("/rest/api/2/status", func(w http.ResponseWriter, r *http.Request) {
+        _, _ = w.Write([]byte(`[{"id": "1", "name": "To Do", "statusCategory": {"key": "new", "name": "To Do"}}]`))
+    })
+    server := httptest.NewServer(mux)
+    t.Cleanup(server.Close)
+    cfg := config{pageSize: 100, changelogConcurrency: 1, requestTimeout: time.Second}
+    instance := instanceConfig{name: "test", jiraURL: server.URL, jiraUser: "u", jiraAPIToken: "t", authMode: authBasic, flavor: flavorServer, searchAPI: searchAPILegacy}
+    return jira, newJiraClient(cfg, instance)
+}
+
+func testIssue(key string, updated time.Time) JiraIssue {
+    var issue JiraIssue
+    issue.Key = key
+    issue.Fields.Created = updated.Add(-time.Hour).Format(jiraTimeFormat)
+    issue.Fields.Updated = updated.Format(jiraTimeFormat)
+    return issue
+}
+
+func storedKeys(s *issueStore) []string {
+    keys := make([]string, 0, len(s.issues))
+    for key := range s.issues {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func TestIssueStoreSync(t *testing.T) {
+    start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+    steps := []struct {
+        name     string
+        at       time.Time
+        issues   []string
+        wantJQL  string
+        wantKeys []string
+    }{
+        {
+            name:     "first sync is full",
+            at:       start,
+            issues:   []string{"A-1", "A-2"},
+            wantJQL:  "project = A ORDER BY key",
+            wantKeys: []string{"A-1", "A-2"},
+        },
+        {
+            name:     "incremental sync merges the updated issues",
+            at:       start.Add(5 * time.Minute),
+            issues:   []string{"A-2", "A-3"},
+            wantJQL:  "(project = A) AND updated >= -10m ORDER BY key",
+            wantKeys: []string{"A-1", "A-2", "A-3"},
+        },
+        {
+            name:     "full sync drops the issues no longer matching",
+            at:       start.Add(time.Hour),
+            issues:   []string{"A-3"},
+            wantJQL:  "project = A ORDER BY key",
+            wantKeys: []string{"A-3"},
+        },
+    }
+    jira, client := newFakeJira(t)
+    cfg := config{fullSyncPeriod: time.Hour, syncOverlap: 5 * time.Minute}
+    store := newIssueStore(queryConfig{name: "default", jql: "project = A ORDER BY key", analyzePeriodDays: 90})
+    for _, step := range steps {
+        t.Run(step.name, func(t *testing.T) {
+            jira.issues = nil
+            for _, key := range step.issues {
+                jira.issues = append(jira.issues, testIssue(key, step.at.Add(-time.Minute)))
+            }
+            fetched, err := store.sync(client, cfg, step.at)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if fetched != len(step.issues) {
+                t.Errorf("fetched = %d, want %d", fetched, len(step.issues))
+            }
+            if jira.jql != step.wantJQL {
+                t.Errorf("JQL = %q, want %q", jira.jql, step.wantJQL)
+            }
+            if keys := storedKeys(store); !slices.Equal(keys, step.wantKeys) {
+                t.Errorf("stored issues = %v, want %v", keys, step.wantKeys)
+            }
+            if !store.syncedAt.Equal(step.at) {
+                t.Errorf("syncedAt = %s, want %s", store.syncedAt, step.at)
+            }
+            if len(store.statuses) != 1 {
+                t.Errorf("statuses = %v, want the fetched one", store.statuses)
+            }
+        })
+    }
+}
+
+func TestIssueStoreSyncEviction(t *testing.T) {
+    now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name     string
+        windowed bool
+        wantKeys []string
+    }{
+        {"windowed query drops the issues updated before the window", true, []string{"A-1"}},
+        {"other query keeps them", false, []string{"A-1", "A-2"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            jira, client := newFakeJira(t)
+            jira.issues = []JiraIssue{testIssue("A-1", now.AddDate(0, 0, -1)), testIssue("A-2", now.AddDate(0, 0, -100))}
+            store := newIssueStore(queryConfig{name: "default", jql: "filter = 1", analyzePeriodDays: 90, windowed: tt.windowed})
+            if _, err := store.sync(client, config{fullSyncPeriod: time.Hour}, now); err != nil {
+                t.Fatal(err)
+            }
+            if keys := storedKeys(store); !slices.Equal(keys, tt.wantKeys) {
+                t.Errorf("stored issues = %v, want %v", keys, tt.wantKeys)
+            }
+        })
+    }
+}
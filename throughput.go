@@ -0,0 +1,78 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "time"
+)
+
+// throughputRow is the number of issues of a project and type completed on a day
+type throughputRow struct {
+    Instance  string `json:"instance"`
+    Query     string `json:"query"`
+    Date      string `json:"date"`
+    Project   string `json:"project"`
+    IssueType string `json:"issueType"`
+    Count     int    `json:"count"`
+}
+
+// dailyThroughput reconstructs from the changelogs how many of the issues were completed
+// on each of the last days, today included. Every project and issue type with completions
+// has a row for every day, so the series are continuous.
+func dailyThroughput(ctx issueContext, issues map[string]JiraIssue, days int) []throughputRow {
+    first := ctx.now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+    type group struct{ project, issueType string }
+    counts := make(map[group][]int)
+    for _, issue := range issues {
+        for _, c := range issueCompletions(ctx, issue) {
+            if c.at.Before(first) || c.at.After(ctx.now) {
+                continue
+            }
+            g := group{project: issue.Fields.Project.Key, issueType: issue.Fields.IssueType.Name}
+            if counts[g] == nil {
+                counts[g] = make([]int, days)
+            }
+            counts[g][int(c.at.Sub(first)/(24*time.Hour))]++
+        }
+    }
+
+    groups := make([]group, 0, len(counts))
+    for g := range counts {
+        groups = append(groups, g)
+    }
+    sort.Slice(groups, func(i, j int) bool {
+        if groups[i].project != groups[j].project {
+            return groups[i].project < groups[j].project
+        }
+        return groups[i].issueType < groups[j].issueType
+    })
+    rows := make([]throughputRow, 0, days*len(groups))
+    for day := 0; day < days; day++ {
+        date := first.AddDate(0, 0, day).Format(time.DateOnly)
+        for _, g := range groups {
+            rows = append(rows, throughputRow{
+                Instance:  ctx.instance,
+                Query:     ctx.query,
+                Date:      date,
+                Project:   g.project,
+                IssueType: g.issueType,
+                Count:     counts[g][day],
+            })
+        }
+    }
+    return rows
+}
+
+// throughputHandler serves the daily throughput of every query as a JSON array of rows,
+// or of the queries matching the instance and query parameters
+func throughputHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        rows := issueMetrics.throughput(r.URL.Query().Get("instance"), r.URL.Query().Get("query"))
+        w.Header().Set("Content-Type", "application/json")
+        if err := json.NewEncoder(w).Encode(rows); err != nil {
+            fmt.Printf("Error writing throughput: %s\n", err)
+        }
+    })
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "slices"
+    "testing"
+    "time"
+)
+
+func statusItem(from string, to string) JiraHistoryItem {
+    return JiraHistoryItem{Field: "status", From: testStatuses[from].id, FromString: from, To: testStatuses[to].id, ToString: to}
+}
+
+func resolutionItem(from string, to string) JiraHistoryItem {
+    item := JiraHistoryItem{Field: "resolution", From: from, To: to}
+    if from != "" {
+        item.FromString = from
+    }
+    if to != "" {
+        item.ToString = to
+    }
+    return item
+}
+
+func TestIssueCompletions(t *testing.T) {
+    at := func(hour int) string { return time.Date(2024, 5, 10, hour, 0, 0, 0, time.UTC).Format(jiraTimeFormat) }
+    tests := []struct {
+        name      string
+        histories []JiraHistory
+        want      []string
+    }{
+        {
+            name: "move and resolution in one entry",
+            histories: []JiraHistory{
+                {ID: "1", Created: at(1), Items: []JiraHistoryItem{statusItem("In Progress", "Done"), resolutionItem("", "10000")}},
+            },
+            want: []string{"1"},
+        },
+        {
+            name: "move and resolution in separate entries",
+            histories: []JiraHistory{
+                {ID: "1", Created: at(1), Items: []JiraHistoryItem{statusItem("In Progress", "Done")}},
+                {ID: "2", Created: at(1), Items: []JiraHistoryItem{resolutionItem("", "10000")}},
+            },
+            want: []string{"1"},
+        },
+        {
+            name: "resolution before the move",
+            histories: []JiraHistory{
+                {ID: "1", Created: at(1), Items: []JiraHistoryItem{resolutionItem("", "10000")}},
+                {ID: "2", Created: at(2), Items: []JiraHistoryItem{statusItem("In Progress", "Done")}},
+            },
+            want: []string{"1"},
+        },
+        {
+            name: "completed again after a reopen",
+            histories: []JiraHistory{
+                {ID: "1", Created: at(1), Items: []JiraHistoryItem{statusItem("In Progress", "Done")}},
+                {ID: "2", Created: at(2), Items: []JiraHistoryItem{resolutionItem("", "10000")}},
+                {ID: "3", Created: at(3), Items: []JiraHistoryItem{statusItem("Done", "In Progress"), resolutionItem("10000", "")}},
+                {ID: "4", Created: at(4), Items: []JiraHistoryItem{statusItem("In Progress", "Done")}},
+                {ID: "5", Created: at(4), Items: []JiraHistoryItem{resolutionItem("", "10000")}},
+            },
+            want: []string{"1", "4"},
+        },
+        {
+            name: "completed again after the resolution is cleared",
+            histories: []JiraHistory{
+                {ID: "1", Created: at(1), Items: []JiraHistoryItem{resolutionItem("", "10000")}},
+                {ID: "2", Created: at(2), Items: []JiraHistoryItem{resolutionItem("10000", "")}},
+                {ID: "3", Created: at(3), Items: []JiraHistoryItem{resolutionItem("", "10001")}},
+            },
+            want: []string{"1", "3"},
+        },
+        {
+            name: "moves within the done category",
+            histories: []JiraHistory{
+                {ID: "1", Created: at(1), Items: []JiraHistoryItem{statusItem("In Progress", "Done")}},
+                {ID: "2", Created: at(2), Items: []JiraHistoryItem{statusItem("Done", "Done")}},
+            },
+            want: []string{"1"},
+        },
+        {
+            name: "never completed",
+            histories: []JiraHistory{
+                {ID: "1", Created: at(1), Items: []JiraHistoryItem{statusItem("To Do", "In Progress")}},
+            },
+            want: nil,
+        },
+    }
+    ctx := issueContext{categories: testStatusCategories()}
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var issue JiraIssue
+            issue.Key = "A-1"
+            issue.Changelog.Histories = tt.histories
+            var got []string
+            for _, c := range issueCompletions(ctx, issue) {
+                got = append(got, c.historyID)
+            }
+            if !slices.Equal(got, tt.want) {
+                t.Errorf("completions = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDailyThroughput(t *testing.T) {
+    day := func(d int, hour int) time.Time { return time.Date(2024, 5, d, hour, 0, 0, 0, time.UTC) }
+    bug := testWorkflowIssue("A-2", day(1, 10), statusChange{day(9, 9), "In Progress"}, statusChange{day(10, 8), "Done"})
+    bug.Fields.IssueType.Name = "Bug"
+    issues := map[string]JiraIssue{
+        // Completed twice: before the window and on its first day
+        "A-1": testWorkflowIssue("A-1", day(1, 10),
+            statusChange{day(5, 9), "Done"},
+            statusChange{day(6, 9), "In Progress"},
+            statusChange{day(8, 9), "Done"},
+        ),
+        "A-2": bug,
+        "A-3": testWorkflowIssue("A-3", day(8, 10), statusChange{day(10, 9), "Done"}),
+        "A-4": testWorkflowIssue("A-4", day(8, 10), statusChange{day(9, 9), "In Progress"}),
+    }
+    for key, issue := range issues {
+        issue.Fields.Project.Key = "A"
+        if issue.Fields.IssueType.Name == "" {
+            issue.Fields.IssueType.Name = "Task"
+        }
+        issues[key] = issue
+    }
+    ctx := issueContext{instance: "default", query: "default", categories: testStatusCategories(), now: day(10, 12)}
+    row := func(date string, issueType string, count int) throughputRow {
+        return throughputRow{Instance: "default", Query: "default", Date: date, Project: "A", IssueType: issueType, Count: count}
+    }
+    want := []throughputRow{
+        row("2024-05-08", "Bug", 0),
+        row("2024-05-08", "Task", 1),
+        row("2024-05-09", "Bug", 0),
+        row("2024-05-09", "Task", 0),
+        row("2024-05-10", "Bug", 1),
+        row("2024-05-10", "Task", 1),
+    }
+    if got := dailyThroughput(ctx, issues, 3); !slices.Equal(got, want) {
+        t.Errorf("dailyThroughput() =\n%v\nwant\n%v", got, want)
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// issuePathPattern matches the issue key in the paths of the issue resources
+var issuePathPattern = regexp.MustCompile(`/issue/[^/]+`)
+
+// instrumentedTransport counts the requests to the Jira instance by endpoint and status code
+type instrumentedTransport struct {
+    instance instanceConfig
+    next     http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := t.next.RoundTrip(req)
+    code := "error"
+    if err == nil {
+        code = strconv.Itoa(resp.StatusCode)
+    }
+    jiraHTTPRequests.WithLabelValues(t.instance.name, endpointTemplate(t.instance.jiraURL, req.URL), code).Inc()
+    return resp, err
+}
+
+// endpointTemplate returns the path of the request relative to the Jira URL, with the
+// issue keys replaced by {key} to keep the number of endpoints bounded
+func endpointTemplate(jiraURL string, u *url.URL) string {
+    path := u.Path
+    if base, err := url.Parse(jiraURL); err == nil {
+        path = strings.TrimPrefix(path, strings.TrimSuffix(base.Path, "/"))
+    }
+    return issuePathPattern.ReplaceAllString(path, "/issue/{key}")
+}